@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	_ "embed"
 	"flag"
 	"fmt"
 	"io"
@@ -15,9 +14,6 @@ import (
 	"github.com/satotake/html2org"
 )
 
-//go:embed VERSION
-var version string
-
 type Option struct {
 	Input           string
 	Output          string
@@ -65,7 +61,7 @@ func main() {
 	opt := parseFlag()
 
 	if opt.Version {
-		fmt.Printf("html2org: HTML to org converter CLI v%s", version)
+		fmt.Printf("html2org: HTML to org converter CLI v%s\n", html2org.Version())
 		os.Exit(0)
 	}
 
@@ -96,9 +92,9 @@ func main() {
 		ShowNoscripts:   opt.Noscript,
 		InternalLinks:   opt.InternalLinks,
 		ShowLongDataURL: opt.ShowLongDataURL,
+		TrailingNewline: true,
 	})
 	check(err)
-	res = res + "\n"
 
 	if opt.Output == "" {
 		fmt.Println(res)
@@ -0,0 +1,115 @@
+package html2org
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// contactField is a field of a contact card, found by its hCard class or
+// its schema.org itemprop.
+type contactField struct {
+	label    string
+	class    string
+	itemprop string
+	scheme   string // URL scheme of links holding the value, e.g. "mailto:"
+}
+
+var contactFields = []contactField{
+	{"Name", "fn", "name", ""},
+	{"Email", "email", "email", "mailto:"},
+	{"Phone", "tel", "telephone", "tel:"},
+}
+
+// isContactCard reports whether node is an hCard (class="vcard") or a
+// schema.org Person or Organization.
+func isContactCard(node *html.Node) bool {
+	if hasAnyClass(node, []string{"vcard", "h-card"}) {
+		return true
+	}
+	itemtype := getAttrVal(node, "itemtype")
+	return hasAttr(node, "itemscope") &&
+		(strings.HasSuffix(itemtype, "schema.org/Person") || strings.HasSuffix(itemtype, "schema.org/Organization"))
+}
+
+// contactCard renders the fields of a contact card as an Org description
+// list, or returns "" if it has none of them.
+func contactCard(card *html.Node) string {
+	lines := []string{}
+	for _, field := range contactFields {
+		if value := contactValue(contactElement(card, field), field); value != "" {
+			lines = append(lines, "- "+field.label+" :: "+value)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isContactField reports whether node is rendered as a field in the list of
+// the contact card holding it, and so is skipped in the rest of the card.
+func isContactField(node *html.Node) bool {
+	matches := false
+	for _, field := range contactFields {
+		matches = matches || field.matches(node)
+	}
+	if !matches {
+		return false
+	}
+	var card *html.Node
+	for p := node.Parent; p != nil && card == nil; p = p.Parent {
+		if isContactCard(p) {
+			card = p
+		}
+	}
+	if card == nil || contactCard(card) == "" {
+		return false
+	}
+	for _, field := range contactFields {
+		if contactElement(card, field) == node {
+			return true
+		}
+	}
+	return false
+}
+
+// contactElement returns the first element of card holding field, or nil.
+func contactElement(card *html.Node, field contactField) *html.Node {
+	var find func(node *html.Node) *html.Node
+	find = func(node *html.Node) *html.Node {
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if field.matches(c) {
+				return c
+			}
+			// fields of nested cards belong to them
+			if !isContactCard(c) {
+				if found := find(c); found != nil {
+					return found
+				}
+			}
+		}
+		return nil
+	}
+	return find(card)
+}
+
+// contactValue returns the value of the element holding field, taken from
+// its link or content attribute if it has one, or "" if element is nil.
+func contactValue(element *html.Node, field contactField) string {
+	if element == nil {
+		return ""
+	}
+	value := textContent(element)
+	href := getAttrVal(element, "href")
+	if field.scheme != "" && strings.HasPrefix(strings.ToLower(href), field.scheme) {
+		value = href[len(field.scheme):]
+	} else if content := getAttrVal(element, "content"); content != "" {
+		value = content
+	}
+	return strings.TrimSpace(cleanSpacing(value))
+}
+
+func (field contactField) matches(node *html.Node) bool {
+	return hasAnyClass(node, []string{field.class}) || getAttrVal(node, "itemprop") == field.itemprop
+}
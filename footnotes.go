@@ -0,0 +1,121 @@
+package html2org
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// footnotes maps the footnote references of a document, sup elements
+// wrapping a single link to a fragment such as
+// <sup><a href="#cite_note-1">[1]</a></sup>, and the elements they refer to
+// to Org footnote labels.
+type footnotes struct {
+	refs  map[*html.Node]string // sup element to label
+	defs  map[*html.Node]string // referenced element to label
+	order []*html.Node          // referenced elements by first reference
+}
+
+// collectFootnotes finds the footnote references of doc whose fragment
+// identifies an element of doc.
+func collectFootnotes(doc *html.Node) *footnotes {
+	ids := map[string]*html.Node{}
+	var sups []*html.Node
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			if id := getAttrVal(node, "id"); id != "" {
+				if _, ok := ids[id]; !ok {
+					ids[id] = node
+				}
+			}
+			if node.DataAtom == atom.Sup && footnoteLink(node) != nil {
+				sups = append(sups, node)
+			}
+		}
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	fns := &footnotes{refs: map[*html.Node]string{}, defs: map[*html.Node]string{}}
+	labels := map[string]bool{}
+	for _, sup := range sups {
+		link := footnoteLink(sup)
+		def := ids[strings.TrimPrefix(getAttrVal(link, "href"), "#")]
+		if def == nil || isAncestor(def, sup) {
+			continue
+		}
+		label, ok := fns.defs[def]
+		if !ok {
+			label = footnoteLabel(textContent(link))
+			if label == "" || labels[label] {
+				label = strconv.Itoa(len(fns.order) + 1)
+			}
+			labels[label] = true
+			fns.defs[def] = label
+			fns.order = append(fns.order, def)
+		}
+		fns.refs[sup] = label
+	}
+	return fns
+}
+
+// footnoteLink returns the only child of sup if it is a link to a fragment.
+func footnoteLink(sup *html.Node) *html.Node {
+	var link *html.Node
+	for c := sup.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == html.TextNode && isBlank(c.Data), c.Type == html.CommentNode:
+		case c.DataAtom == atom.A && link == nil:
+			link = c
+		default:
+			return nil
+		}
+	}
+	if link == nil || len(getAttrVal(link, "href")) < 2 || !strings.HasPrefix(getAttrVal(link, "href"), "#") {
+		return nil
+	}
+	return link
+}
+
+// footnoteLabel turns the text of a reference such as "[1]" or "a" into a
+// label, or returns "" if it cannot be one.
+func footnoteLabel(text string) string {
+	label := strings.Trim(strings.TrimSpace(text), "[]()")
+	for _, r := range label {
+		if !(r == '-' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return ""
+		}
+	}
+	return label
+}
+
+func isAncestor(ancestor, node *html.Node) bool {
+	for p := node.Parent; p != nil; p = p.Parent {
+		if p == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// footnoteDefinitions renders the referenced elements as Org footnote
+// definitions, without the links back to the references.
+func (ctx *textifyTraverseContext) footnoteDefinitions() (string, error) {
+	defs := []string{}
+	for _, def := range ctx.footnotes.order {
+		subCtx := ctx.newSubContext()
+		subCtx.isInFootnote = true
+		if err := subCtx.traverseChildren(def); err != nil {
+			return "", err
+		}
+		text := strings.TrimSpace(cleanSpacing(subCtx.buf.String()))
+		defs = append(defs, fmt.Sprintf("[fn:%s] %s", ctx.footnotes.defs[def], text))
+	}
+	return strings.Join(defs, "\n"), nil
+}
@@ -0,0 +1,102 @@
+package html2org
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Form describes a form element of a document.
+type Form struct {
+	ID     string // id the form is rendered with, e.g. "org-form-id--1"
+	Action string // action attribute as written
+	Method string // "get" unless set
+	Fields []FormField
+}
+
+// FormField describes an input, textarea or select element of a form.
+type FormField struct {
+	Type  string // input type ("unknown" unless set), "textarea" or "select"
+	Name  string
+	Value string // value attribute, textarea content or selected option value
+}
+
+// ExtractForms returns the forms of doc in document order. Forms are
+// numbered as they are when doc is converted, so the ids match the
+// org-form links of the converted text, even when options such as
+// DropSelectors or BodyOnly skip some of the forms.
+func ExtractForms(doc *html.Node) []Form {
+	forms := []Form{}
+	ids := numberForms(doc)
+	var visit func(node *html.Node)
+	visit = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.DataAtom == atom.Form {
+			forms = append(forms, Form{
+				ID:     fmt.Sprintf(orgFormIDFormat, ids[node]),
+				Action: getAttrVal(node, "action"),
+				Method: formMethod(node),
+				Fields: formFields(node, []FormField{}),
+			})
+		}
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(doc)
+	return forms
+}
+
+func formFields(node *html.Node, fields []FormField) []FormField {
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		switch c.DataAtom {
+		case atom.Input:
+			fields = append(fields, FormField{inputType(c), getAttrVal(c, "name"), getAttrVal(c, "value")})
+			continue
+		case atom.Textarea:
+			fields = append(fields, FormField{"textarea", getAttrVal(c, "name"), textContent(c)})
+			continue
+		case atom.Select:
+			fields = append(fields, FormField{"select", getAttrVal(c, "name"), selectedOption(c)})
+			continue
+		}
+		fields = formFields(c, fields)
+	}
+	return fields
+}
+
+// selectedOption returns the value of the selected option of a select
+// element, the first option if none is selected, as browsers submit it.
+func selectedOption(node *html.Node) string {
+	var first, selected *html.Node
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		for c := n.FirstChild; c != nil && selected == nil; c = c.NextSibling {
+			if c.DataAtom != atom.Option {
+				visit(c)
+				continue
+			}
+			if first == nil {
+				first = c
+			}
+			if hasAttr(c, "selected") {
+				selected = c
+			}
+		}
+	}
+	visit(node)
+	if selected == nil {
+		selected = first
+	}
+	if selected == nil {
+		return ""
+	}
+	if hasAttr(selected, "value") {
+		return getAttrVal(selected, "value")
+	}
+	return strings.TrimSpace(textContent(selected))
+}
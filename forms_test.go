@@ -0,0 +1,72 @@
+package html2org
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestExtractForms(t *testing.T) {
+	input := `<p>Search</p>
+<form action="/search" method="post">
+	<label>Query <input type="text" name="q" value="org"></label>
+	<select name="lang"><option value="en">English</option><option value="ja" selected>Japanese</option></select>
+	<input type="submit" value="Go">
+</form>
+<form><textarea name="body">hello</textarea></form>`
+	doc, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Form{
+		{
+			ID:     "org-form-id--1",
+			Action: "/search",
+			Method: "post",
+			Fields: []FormField{
+				{Type: "text", Name: "q", Value: "org"},
+				{Type: "select", Name: "lang", Value: "ja"},
+				{Type: "submit", Value: "Go"},
+			},
+		},
+		{
+			ID:     "org-form-id--2",
+			Method: "get",
+			Fields: []FormField{
+				{Type: "textarea", Name: "body", Value: "hello"},
+			},
+		},
+	}
+	if got := ExtractForms(doc); !reflect.DeepEqual(got, want) {
+		t.Errorf("\ngot : %+v\nwant: %+v", got, want)
+	}
+
+	// ids match the links of the converted text
+	text, err := FromHTMLNode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, form := range want {
+		if !strings.Contains(text, "[[org-form:"+form.ID+":"+form.Method+":") {
+			t.Errorf("\n%s\ndoes not link %s", text, form.ID)
+		}
+	}
+}
+
+func TestExtractFormsSkipped(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<form class="ad"><input name="a"></form><form><input name="b"></form>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forms := ExtractForms(doc)
+	text, err := FromHTMLNode(doc, Options{DropSelectors: []string{".ad"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(text, forms[0].ID) || !strings.Contains(text, "[[org-form:"+forms[1].ID+":") {
+		t.Errorf("\n%s\ndoes not link %s alone", text, forms[1].ID)
+	}
+}
@@ -0,0 +1,104 @@
+package html2org
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// FrontMatter selects the front matter emitted for static site generators.
+type FrontMatter int
+
+const (
+	// FrontMatterNone emits the title as an Org #+TITLE keyword.
+	FrontMatterNone FrontMatter = iota
+	// FrontMatterYAML emits the title and keywords between --- lines.
+	FrontMatterYAML
+	// FrontMatterTOML emits the title and keywords between +++ lines.
+	FrontMatterTOML
+)
+
+// documentMeta is the metadata of a document put in its front matter.
+type documentMeta struct {
+	title    string
+	keywords []string
+}
+
+// collectMeta returns the title and the meta keywords of the head of doc.
+func collectMeta(doc *html.Node) documentMeta {
+	var meta documentMeta
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		switch node.DataAtom {
+		case atom.Title:
+			if meta.title == "" {
+				meta.title = strings.TrimSpace(cleanSpacing(textContent(node)))
+			}
+			return
+		case atom.Meta:
+			if strings.EqualFold(getAttrVal(node, "name"), "keywords") {
+				for _, k := range strings.Split(getAttrVal(node, "content"), ",") {
+					if k = strings.TrimSpace(cleanSpacing(k)); k != "" {
+						meta.keywords = append(meta.keywords, k)
+					}
+				}
+			}
+			return
+		case atom.Body:
+			return
+		}
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return meta
+}
+
+// frontMatter renders meta in format, or returns "" if meta is empty.
+func (meta documentMeta) frontMatter(format FrontMatter) string {
+	if meta.title == "" && len(meta.keywords) == 0 {
+		return ""
+	}
+	fence, assign, quote := "---", ": ", strconv.Quote
+	if format == FrontMatterTOML {
+		fence, assign, quote = "+++", " = ", tomlQuote
+	}
+	buf := bytes.Buffer{}
+	buf.WriteString(fence + "\n")
+	if meta.title != "" {
+		buf.WriteString("title" + assign + quote(meta.title) + "\n")
+	}
+	if len(meta.keywords) > 0 {
+		quoted := make([]string, len(meta.keywords))
+		for i, k := range meta.keywords {
+			quoted[i] = quote(k)
+		}
+		buf.WriteString("keywords" + assign + "[" + strings.Join(quoted, ", ") + "]\n")
+	}
+	buf.WriteString(fence + "\n\n")
+	return buf.String()
+}
+
+// tomlQuote returns s as a TOML basic string. Unlike strconv.Quote, it only
+// uses the escapes TOML knows of.
+func tomlQuote(s string) string {
+	buf := bytes.Buffer{}
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			buf.WriteString(`\` + string(r))
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&buf, `\u%04X`, r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
@@ -0,0 +1,56 @@
+//go:build go1.18
+// +build go1.18
+
+package html2org
+
+import (
+	"testing"
+)
+
+// fuzzOptions derives the options of a fuzzed conversion from flags, so
+// that the code paths behind the options are fuzzed too.
+func fuzzOptions(flags uint16) Options {
+	return Options{
+		PrettyTables:         flags&(1<<0) != 0,
+		SimpleTables:         flags&(1<<1) != 0,
+		BreakLongLines:       flags&(1<<2) != 0,
+		PreserveSoftBreaks:   flags&(1<<3) != 0,
+		DetailsAsDrawer:      flags&(1<<4) != 0,
+		PreKeepMarkup:        flags&(1<<5) != 0,
+		DedupeAdjacentLinks:  flags&(1<<6) != 0,
+		ContinueOrderedLists: flags&(1<<7) != 0,
+		NumberHeadings:       flags&(1<<8) != 0,
+		HrStyle:              HrStyle(flags >> 9 & 3),
+		BaseURL:              "https://example.com/a/",
+		MaxLineLen:           int(flags >> 11 & 31),
+	}
+}
+
+func FuzzFromString(f *testing.F) {
+	seeds := []string{
+		``,
+		`<p>text</p>`,
+		`<blockquote><p>` + "quote with a rather long line that needs to be broken somewhere" + `</p><br></blockquote>`,
+		`<table><tr><th colspan="3">a</th></tr><tr><td rowspan="2">b</td><td>c</td></tr><tr></tr></table>`,
+		`<table><colgroup><col span="2" align="right"></colgroup><tr><td></td><td> </td></tr></table>`,
+		`<table><caption>c</caption><thead><tr><td>a<table><tr><td>x</td></tr></table></td></tr></thead></table>`,
+		`<figure><table><tr><td>a</td></tr></table><figcaption>Table 1</figcaption></figure>`,
+		`<ol start="-3" reversed><li>a</li><p>b</p><li><details><summary>s</summary><p>d</p></details></li></ol><ol><li>c</li></ol>`,
+		`<a href="%zz#%_toc"><h2>Heading</h2></a><a href="javascript:void(0)"><img alt="x"></a>`,
+		`<a href="/x"><img src="/i.png"></a> <a href="/x">Home</a>`,
+		`<pre lang="go"><code class="language-">x := <b>1</b></code></pre><pre> </pre>`,
+		`<h1>a</h1><h3>b</h3><h2>c</h2><hr><p>d</p><hr><hr>`,
+		`<p>a<br>b<br></p><b> </b><i></i><q lang="fr">q<q>r</q></q>`,
+		`<form action="?q"><input type="checkbox"><textarea>t</textarea><select><option>o</option></select></form>`,
+		"<p>​*bold*⁦</p><code>a\nb</code>",
+	}
+	for i, seed := range seeds {
+		f.Add(seed, uint16(i*2731))
+	}
+
+	f.Fuzz(func(t *testing.T, input string, flags uint16) {
+		if _, err := FromString(input, fuzzOptions(flags)); err != nil {
+			t.Skip(err)
+		}
+	})
+}
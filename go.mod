@@ -3,6 +3,7 @@ module github.com/satotake/html2org
 go 1.16
 
 require (
+	github.com/mattn/go-runewidth v0.0.9
 	github.com/olekukonko/tablewriter v0.0.5
 	github.com/ssor/bom v0.0.0-20170718123548-6386211fdfcf
 	golang.org/x/net v0.0.0-20210410081132-afb366fc7cd1
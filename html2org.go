@@ -2,13 +2,17 @@ package html2org
 
 import (
 	"bytes"
+	_ "embed"
 	"fmt"
 	"io"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 
+	"github.com/mattn/go-runewidth"
 	"github.com/olekukonko/tablewriter"
 	"github.com/ssor/bom"
 	"golang.org/x/net/html"
@@ -17,25 +21,129 @@ import (
 
 const orgFormIDFormat = "org-form-id--%d"
 
+//go:embed VERSION
+var version string
+
+// Version returns the version of the converter, e.g. "0.0.12".
+func Version() string {
+	return strings.TrimSpace(version)
+}
+
 var allowedInputTypes = map[string]struct{}{
 	"text":     {},
 	"number":   {},
 	"password": {},
+	"email":    {},
+	"url":      {},
+	"tel":      {},
+	"search":   {},
+	"date":     {},
+	"time":     {},
+	"range":    {},
 	"unknown":  {},
 }
 
+var buttonInputTypes = map[string]struct{}{
+	"submit": {},
+	"reset":  {},
+	"button": {},
+}
+
 // Options provide toggles and overrides to control specific rendering behaviors.
 type Options struct {
-	PrettyTables        bool                 // Turns on pretty ASCII rendering for table elements.
-	PrettyTablesOptions *PrettyTablesOptions // Configures pretty ASCII rendering for table elements.
-	OmitLinks           bool                 // Turns on omitting links
-	BreakLongLines      bool
-	BaseURL             string
-	ShowNoscripts       bool
-	InternalLinks       bool
-	ShowLongDataURL     bool
+	PrettyTables         bool                 // Turns on pretty ASCII rendering for table elements.
+	PrettyTablesOptions  *PrettyTablesOptions // Configures pretty ASCII rendering for table elements.
+	OmitLinks            bool                 // Turns on omitting links
+	BreakLongLines       bool
+	BaseURL              string
+	ShowNoscripts        bool
+	InternalLinks        bool
+	ShowLongDataURL      bool
+	HonorInlineStyles    bool     // Treats elements styled with `white-space: pre` as preformatted
+	AsciiPunctuation     bool     // Replaces typographic quotes, dashes and ellipses with ASCII
+	LocalImagesAsFile    bool     // Renders local (file:// or relative, but not root-relative) image sources as Org file: links
+	EmitCite             bool     // Appends the cite attribute of blockquote and q elements as a link
+	EmptyAltPlaceholder  string   // Caption for images with an explicitly empty alt attribute
+	DropLazyImages       bool     // Skips images with loading="lazy"
+	ChecklistStats       bool     // Adds a statistics cookie like [1/3] to items containing a checklist, indenting the checklist below them
+	ExpandAbbreviations  bool     // Appends the title of abbr elements in parentheses
+	SkipEmptyPre         bool     // Omits pre elements containing only whitespace instead of emitting an empty block
+	DetailsAsDrawer      bool     // Wraps the content of closed details elements in a :DETAILS: drawer
+	EmitBidiMarkers      bool     // Wraps bdi content in first strong isolate (U+2068) and pop directional isolate (U+2069)
+	PreformattedClasses  []string // Classes marking elements as preformatted, e.g. "whitespace-pre"
+	HrStyle              HrStyle  // Selects how hr elements are rendered
+	FlattenSpans         bool     // Unwraps span elements carrying only class or style attributes
+	MaxLineLen           int      // Maximum line width in columns used by BreakLongLines (default 74)
+	ArticleSeparator     string   // Emitted between sibling top-level article elements, e.g. "-----"
+	KeepRelativeLinks    bool     // Leaves relative link hrefs as written even if BaseURL is set
+	BodyOnly             bool     // Skips the head element, including the title
+	VerseClasses         []string // Classes marking elements as verse, rendered in a verse block
+	ImageTitles          bool     // Adds image titles to the caption: "alt (title)", or "title" without alt
+	ContinueOrderedLists bool     // Continues numbering from the previous sibling <ol> unless start is given
+	PreserveSoftBreaks   bool     // Keeps line breaks between words of a paragraph as Org forced line breaks (\\)
+	PreKeepMarkup        bool     // Renders pre elements not holding code as verse blocks, keeping inline markup
+	AlwaysExplicitLinks  bool     // Emits [[href][text]] even when the link text equals the href
+	NumberHeadings       bool     // Prefixes headlines with their section number, e.g. "1.2 Background"
+	SampAsOutput         bool     // Renders samp (program output) as =verbatim= or an example block, unlike kbd input
+	CaptureLinkData      []string // Link attributes, e.g. "data-track" or "data-ga-*", kept in a comment after the link
+	InputBlockName       string   // Name of the block input elements are rendered in (default "input")
+	TextareaBlockName    string   // Name of the block textarea elements are rendered in (default "textarea")
+	RawPassthroughTags   []string // Tags, e.g. "svg", whose HTML is kept as is in a #+begin_export html block
+	SimpleTables         bool     // Renders tables as unaligned Org tables without tablewriter, unless PrettyTables is set
+	KeepFragment         bool     // Keeps the #fragment of link hrefs even when it is lost resolving them against BaseURL
+	DropSelectors        []string // Skips elements matching CSS selectors made of tags, .class, #id and descendant combinators
+	ImageWidths          bool     // Emits #+ATTR_ORG: :width for images with a width in pixels
+	QuoteLocale          string   // Locale, e.g. "en", "de", "fr" or "ja", of the quotation marks around q elements; ASCII quotes by default
+	DedupeAdjacentLinks  bool     // Merges links to the same href separated by spaces only, e.g. an icon and a text link, keeping the longest label
+	TrailingNewline      bool     // Ends the output with a newline, as expected of text files
+	PreferredMedia       string   // Media query, e.g. "(min-width: 800px)", whose source of a picture element replaces the src of its img
+	PreCollapseSpaces    bool     // Collapses runs of spaces inside pre lines, keeping line breaks and leading indentation
+	KeepEmptyParagraphs  bool     // Keeps an extra blank line for each empty p element used as a spacer, up to three blank lines in a row
+	WbrBreaks            bool     // With BreakLongLines, breaks a word too long for the rest of the line at its last wbr element
+	FootnoteRefs         bool     // Renders sup links to a fragment as Org footnote references, defined at the end by the linked elements
+	ContactCards         bool     // Renders the name, email and phone of hCard and schema.org Person or Organization elements as a description list before their other content
+	DropLinkRels         []string // Rel values, e.g. "sponsored", whose links are rendered as their text only
+	GenerateHeadingSlugs bool     // Gives headings a :CUSTOM_ID: property of their id, or else of a slug of their text, e.g. "getting-started"
+	OmitImages           bool     // Drops img elements, leaving links around them with their other text or their href only
+	DetailsAsHeadline    bool     // Renders details outside list items, table cells and quotes as a headline of their summary below the current heading, so Org folds their content
+	DivAsParagraph       bool     // Separates div elements by blank lines like paragraphs instead of line breaks
+	QuoteAttribution     bool     // Moves the footer or cite element of a blockquote after the block as an attribution line, e.g. "— Author"
+	AutoLinkify          bool     // Turns bare http(s) URLs and email addresses in text outside links and code into Org links
+
+	// FrontMatter emits the title and the meta keywords as YAML or TOML
+	// front matter at the top instead of a #+TITLE keyword.
+	FrontMatter FrontMatter
+
+	// CalloutClassMap maps a class of blockquote and div callouts to the name
+	// of the Org block they are rendered in, e.g. "note" renders #+begin_note.
+	CalloutClassMap map[string]string
+
+	// TransformLinkText rewrites the label of each link. Returning an empty
+	// label renders a bare [[href]] link.
+	TransformLinkText func(text, href string) string
+
+	// OnProgress is called with the number of HTML nodes processed so far
+	// every progressInterval nodes and once more when the conversion ends,
+	// so long conversions can report their status.
+	OnProgress func(nodesProcessed int)
 }
 
+// HrStyle selects the rendering of hr elements.
+type HrStyle int
+
+const (
+	// HrRule renders an Org horizontal rule (-----).
+	HrRule HrStyle = iota
+	// HrBlankLine renders a blank line only.
+	HrBlankLine
+	// HrAsterisks renders a centered "* * *" break.
+	HrAsterisks
+	// HrSmart renders a blank line for an hr which is the sole separator
+	// between two similar blocks, i.e. elements with the same tag and class
+	// such as two dl groups, and a rule for any other hr.
+	HrSmart
+)
+
 // PrettyTablesOptions overrides tablewriter behaviors
 type PrettyTablesOptions struct {
 	AutoFormatHeader     bool
@@ -55,6 +163,13 @@ type PrettyTablesOptions struct {
 	AutoMergeCells       bool
 	Borders              tablewriter.Border
 	OrgFormat            bool
+	ForceHeaderSeparator bool // Separates the first row of a headerless table as if it were a header
+	DropEmptyColumns     bool // Removes columns whose cells are all empty, including header and footer
+	MaxCells             int  // Stops collecting the cells of a table after this many, noting the truncation below it; 0 for no limit
+
+	// Transform, if set, rewrites the cells of each table before it is
+	// rendered, e.g. to sort the rows or reorder the columns.
+	Transform func(header []string, body [][]string, footer []string) ([]string, [][]string, []string)
 }
 
 // NewPrettyTablesOptions creates PrettyTablesOptions with default settings
@@ -81,7 +196,37 @@ func NewPrettyTablesOptions() *PrettyTablesOptions {
 }
 
 // FromHTMLNode renders text output from a pre-parsed HTML document.
+//
+// Each conversion uses its own traversal state, so FromHTMLNode, FromReader,
+// FromBytes and FromString are safe for concurrent use by multiple goroutines.
+// The doc node is only read and may be shared between concurrent calls.
 func FromHTMLNode(doc *html.Node, o ...Options) (string, error) {
+	text, _, err := fromHTMLNode(doc, o...)
+	return text, err
+}
+
+// Stats counts the elements emitted by a conversion.
+type Stats struct {
+	// Links counts the links written to the output, for a elements with an
+	// href and by AutoLinkify. Links merged by DedupeAdjacentLinks count once.
+	Links      int
+	Images     int
+	Tables     int
+	Headings   int
+	CodeBlocks int
+	Forms      int
+}
+
+// FromStringWithStats works like FromString and additionally reports what was emitted.
+func FromStringWithStats(input string, options ...Options) (string, Stats, error) {
+	doc, err := parseBytes([]byte(input))
+	if err != nil {
+		return "", Stats{}, err
+	}
+	return fromHTMLNode(doc, options...)
+}
+
+func fromHTMLNode(doc *html.Node, o ...Options) (string, Stats, error) {
 	var options Options
 	if len(o) > 0 {
 		options = o[0]
@@ -90,19 +235,72 @@ func FromHTMLNode(doc *html.Node, o ...Options) (string, error) {
 	ctx := textifyTraverseContext{
 		buf:         bytes.Buffer{},
 		fragmentIDs: map[string]struct{}{},
+		forms:       &formIDAllocator{doc: doc},
+		stats:       &Stats{},
+		headings:    &headingCounter{},
+		progress:    &nodeCounter{report: options.OnProgress},
 		options:     options,
 	}
+	ctx.lastOrderedList = map[*html.Node]*orderedListContext{}
+	for _, s := range options.DropSelectors {
+		sel, err := parseSelector(s)
+		if err != nil {
+			return "", Stats{}, err
+		}
+		ctx.dropSelectors = append(ctx.dropSelectors, sel)
+	}
 	ctx.collectFragmentIDs(doc)
+	if options.KeepEmptyParagraphs {
+		ctx.paragraphMark = emptyParagraphMark(doc)
+	}
+	if options.GenerateHeadingSlugs {
+		ctx.headings.reserveIDs(doc)
+	}
+	if options.FootnoteRefs {
+		ctx.footnotes = collectFootnotes(doc)
+	}
+	// BodyOnly ignores the head the metadata is taken from
+	if options.FrontMatter != FrontMatterNone && !options.BodyOnly {
+		ctx.emit(collectMeta(doc).frontMatter(options.FrontMatter))
+	}
 	if err := ctx.traverse(doc); err != nil {
-		return "", err
+		return "", Stats{}, err
 	}
+	if ctx.footnotes != nil && len(ctx.footnotes.order) > 0 {
+		defs, err := ctx.footnoteDefinitions()
+		if err != nil {
+			return "", Stats{}, err
+		}
+		ctx.emit("\n\n" + defs + "\n")
+	}
+	ctx.progress.done()
+
+	text := postProcess(ctx.buf.String(), ctx.paragraphMark)
+	if options.TrailingNewline {
+		text += "\n"
+	}
+	return text, *ctx.stats, nil
+}
 
-	text := ctx.buf.String()
+// postProcess normalizes spacing of the rendered text. The marks of empty
+// paragraphs, if any, are turned into blank lines.
+func postProcess(text, paragraphMark string) string {
 	text = trailingSpaceRe.ReplaceAllString(text, "\n")
 	text = newlineRe.ReplaceAllString(text, "\n\n")
+	if paragraphMark != "" {
+		// a blank line followed by empty paragraph marks
+		emptyParagraphsRe := regexp.MustCompile("\n\n(?:" + paragraphMark + "\n\n)+")
+		text = emptyParagraphsRe.ReplaceAllStringFunc(text, func(marks string) string {
+			n := strings.Count(marks, paragraphMark)
+			if n > maxConsecutiveBlankLines-1 {
+				n = maxConsecutiveBlankLines - 1
+			}
+			return strings.Repeat("\n", n+2)
+		})
+		text = strings.Replace(text, paragraphMark, "", -1)
+	}
 	text = normalizeNonBreakingSpace(text)
-	text = strings.TrimSpace(text)
-	return text, nil
+	return strings.TrimSpace(text)
 }
 
 // FromReader renders text output after parsing HTML for the specified
@@ -119,18 +317,37 @@ func FromReader(reader io.Reader, options ...Options) (string, error) {
 	return FromHTMLNode(doc, options...)
 }
 
-// FromString parses HTML from the input string, then renders the text form.
-func FromString(input string, options ...Options) (string, error) {
-	bs := bom.CleanBom([]byte(input))
-	text, err := FromReader(bytes.NewReader(bs), options...)
+// FromBytes parses HTML from the input bytes, then renders the text form.
+func FromBytes(input []byte, options ...Options) (string, error) {
+	doc, err := parseBytes(input)
 	if err != nil {
 		return "", err
 	}
-	return text, nil
+	return FromHTMLNode(doc, options...)
+}
+
+// parseBytes parses the HTML document of the input bytes, dropping any byte
+// order mark first.
+func parseBytes(input []byte) (*html.Node, error) {
+	return html.Parse(bytes.NewReader(bom.CleanBom(input)))
+}
+
+// FromString parses HTML from the input string, then renders the text form.
+func FromString(input string, options ...Options) (string, error) {
+	return FromBytes([]byte(input), options...)
 }
 
+var byteOrderMarkReplacer = strings.NewReplacer(" \ufeff ", " ", "\ufeff", "")
+
+var asciiPunctuationReplacer = strings.NewReplacer(
+	"‘", "'", "’", "'", "‚", "'", "‛", "'",
+	"“", `"`, "”", `"`, "„", `"`, "‟", `"`,
+	"–", "--", "—", "---", "…", "...",
+)
+
 var (
 	spacingRe       = regexp.MustCompile(`[ \r\n\t]+`)
+	softBreakRe     = regexp.MustCompile(`[ \r\t]*\n[ \r\n\t]*`)
 	newlineRe       = regexp.MustCompile(`\n\n+`)
 	trailingSpaceRe = regexp.MustCompile(` +\n`)
 )
@@ -140,17 +357,261 @@ type textifyTraverseContext struct {
 	buf bytes.Buffer
 
 	prefix          string
-	tableCtx        tableTraverseContext
+	tableCtxs       []*tableTraverseContext            // innermost table last
+	orderedList     *orderedListContext                // nil unless rendering the items of an ol
+	itemIndent      int                                // width of the bullet of the last list item
+	lastOrderedList map[*html.Node]*orderedListContext // last ol rendered under each parent; shared by all sub contexts
+	isInTableCell   bool
+	isInLinkLabel   bool // rendering the description of a link, where images stand for their alt text
 	options         Options
 	endsWithSpace   bool
 	endsWithNewLine bool
 	justClosedDiv   bool
 	blockquoteLevel int
+	justOpenedQuote bool // nothing written since a quote block began
+	quoteParaEnded  bool // nothing written since a paragraph in a quote ended
 	lineLength      int
 	isPreFormatted  bool
+	isInVerse       bool // inside a verse block, which keeps markup unlike other preformatted blocks
+	isVerbatim      bool // inside translate="no"; punctuation must not be altered
 	isInForm        bool
-	formCounter     int
+	formID          int              // id of the form being rendered
+	forms           *formIDAllocator // shared by all sub contexts of a document
+	stats           *Stats           // shared by all sub contexts of a document
+	headings        *headingCounter  // shared by all sub contexts of a document
+	progress        *nodeCounter     // shared by all sub contexts of a document
+	dropSelectors   []selector       // parsed options.DropSelectors
 	fragmentIDs     map[string]struct{}
+	lastLink        *emittedLink // for options.DedupeAdjacentLinks
+	tableCaption    string       // figcaption of the figure holding the next table
+	wbrAt           int          // buffer offset of the last wbr element, for options.WbrBreaks
+	footnotes       *footnotes   // shared by all sub contexts of a document, for options.FootnoteRefs
+	isInFootnote    bool         // rendering a footnote definition, where links back to the references are dropped
+	continuation    *html.Node   // the element handleListContinuation renders
+	isAfterListItem bool         // an item of the list being rendered has been seen
+	isInBlockLink   bool         // rendering the blocks inside a link
+	headingNumber   string       // number of the heading leading the blocks of a link, put before the link
+	headingDrawer   string       // property drawer of the heading leading the blocks of a link, emitted after the link
+	paragraphMark   string       // stands for an empty paragraph, for options.KeepEmptyParagraphs
+}
+
+// emittedLink locates a link in the buffer of the context.
+type emittedLink struct {
+	href, label string
+	start, end  int
+}
+
+// progressInterval is the number of nodes between Options.OnProgress calls.
+const progressInterval = 1000
+
+// nodeCounter counts the nodes traversed and reports them to
+// Options.OnProgress.
+type nodeCounter struct {
+	nodes  int
+	report func(nodesProcessed int)
+}
+
+func (p *nodeCounter) visit() {
+	p.nodes++
+	if p.report != nil && p.nodes%progressInterval == 0 {
+		p.report(p.nodes)
+	}
+}
+
+func (p *nodeCounter) done() {
+	if p.report != nil && p.nodes%progressInterval != 0 {
+		p.report(p.nodes)
+	}
+}
+
+// lineMarkupRe matches the markup starting a line of a headline or a list
+// item, e.g. "*** ", "- " or "2. [@2] ".
+var lineMarkupRe = regexp.MustCompile(`^(?:\*+|[-+]|\d+[.)](?: \[@\d+\])?) `)
+
+// maxBlockLinkLabel is the length up to which the text of the blocks inside
+// a link becomes the link label.
+const maxBlockLinkLabel = 80
+
+// blockLinkLabel splits the rendering of the blocks inside link into their
+// markup and their text, e.g. "*** " and "Heading" or "- " and "Item", when
+// it is a single short line of text which can serve as the link label.
+func blockLinkLabel(link *html.Node, rendered string) (markup, label string, ok bool) {
+	rendered = strings.TrimSpace(rendered)
+	markup = lineMarkupRe.FindString(rendered)
+	label = strings.TrimPrefix(rendered, markup)
+	if isBlank(textContent(link)) || label == "" || strings.Contains(rendered, "\n") ||
+		strings.Contains(label, "[[") || utf8.RuneCountInString(label) > maxBlockLinkLabel {
+		return "", "", false
+	}
+	return markup, label, true
+}
+
+// orderedListContext numbers the items of an ordered list.
+type orderedListContext struct {
+	next    int // number of the next item as counted by browsers
+	step    int // 1, or -1 for reversed lists
+	orgNext int // number Org gives the next item unless it has a counter cookie
+}
+
+func newOrderedListContext(ol *html.Node) *orderedListContext {
+	listCtx := &orderedListContext{next: 1, step: 1, orgNext: 1}
+	if hasAttr(ol, "reversed") {
+		listCtx.step = -1
+		listCtx.next = 0
+		for c := ol.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.Li {
+				listCtx.next++
+			}
+		}
+	}
+	if start, err := strconv.Atoi(strings.TrimSpace(getAttrVal(ol, "start"))); err == nil {
+		listCtx.next = start
+	}
+	return listCtx
+}
+
+// continueOrderedList makes the ol being rendered continue the numbering
+// of the previous ol under the same parent, unless either is reversed or
+// the ol sets its own start.
+func (ctx *textifyTraverseContext) continueOrderedList(ol *html.Node) {
+	prev := ctx.lastOrderedList[ol.Parent]
+	ctx.lastOrderedList[ol.Parent] = ctx.orderedList
+	if prev == nil || prev.step != 1 || ctx.orderedList.step != 1 || hasAttr(ol, "start") {
+		return
+	}
+	ctx.orderedList.next = prev.next
+}
+
+// itemNumber returns the number of the list item li and advances the counter.
+func (listCtx *orderedListContext) itemNumber(li *html.Node) int {
+	if value, err := strconv.Atoi(strings.TrimSpace(getAttrVal(li, "value"))); err == nil {
+		listCtx.next = value
+	}
+	n := listCtx.next
+	listCtx.next += listCtx.step
+	return n
+}
+
+// bullet returns the Org bullet of item number n, with a counter cookie
+// when Org would number the item differently.
+func (listCtx *orderedListContext) bullet(n int) string {
+	b := fmt.Sprintf("%d. ", n)
+	if n != listCtx.orgNext {
+		b += fmt.Sprintf("[@%d] ", n)
+	}
+	listCtx.orgNext = n + 1
+	return b
+}
+
+// headingCounter numbers headings hierarchically, e.g. 1.2.1.
+type headingCounter struct {
+	counts [6]int
+	top    int             // level of the shallowest heading so far, 0 before the first
+	slugs  map[string]bool // ids of the document and slugs so far
+	level  int             // level of the current headline, 0 before the first
+}
+
+// number returns the number of the next heading of level 1 (h1) to 6 (h6)
+// and restarts the numbering of deeper levels.
+func (c *headingCounter) number(level int) string {
+	// documents starting at h2 are numbered from their first level, and a
+	// later h1 continues the numbering of the h2 before it
+	if c.top == 0 || level < c.top {
+		if c.top != 0 {
+			c.counts[level-1] = c.counts[c.top-1]
+		}
+		c.top = level
+	}
+	c.counts[level-1]++
+	for i := level; i < len(c.counts); i++ {
+		c.counts[i] = 0
+	}
+	parts := []string{}
+	for _, n := range c.counts[c.top-1 : level] {
+		parts = append(parts, strconv.Itoa(n))
+	}
+	return strings.Join(parts, ".")
+}
+
+var slugSeparatorsRe = regexp.MustCompile(`[^\pL\pN]+`)
+
+// slug returns a lowercase, hyphenated slug of text which no heading has
+// used yet, adding a numeric suffix on collisions, e.g. "intro-1".
+func (c *headingCounter) slug(text string) string {
+	base := strings.Trim(slugSeparatorsRe.ReplaceAllString(strings.ToLower(text), "-"), "-")
+	if base == "" {
+		base = "section"
+	}
+	slug := base
+	for n := 1; c.slugs[slug]; n++ {
+		slug = base + "-" + strconv.Itoa(n)
+	}
+	c.reserve(slug)
+	return slug
+}
+
+// reserve marks id as used so that no slug collides with it.
+func (c *headingCounter) reserve(id string) {
+	if c.slugs == nil {
+		c.slugs = map[string]bool{}
+	}
+	c.slugs[id] = true
+}
+
+// reserveIDs reserves the ids of all the elements of node, so that no slug
+// collides with an id of the document, wherever it comes.
+func (c *headingCounter) reserveIDs(node *html.Node) {
+	if id := strings.TrimSpace(getAttrVal(node, "id")); id != "" {
+		c.reserve(id)
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		c.reserveIDs(child)
+	}
+}
+
+func (ctx *textifyTraverseContext) inputBlockName() string {
+	if ctx.options.InputBlockName != "" {
+		return ctx.options.InputBlockName
+	}
+	return "input"
+}
+
+func (ctx *textifyTraverseContext) textareaBlockName() string {
+	if ctx.options.TextareaBlockName != "" {
+		return ctx.options.TextareaBlockName
+	}
+	return "textarea"
+}
+
+// formIDAllocator assigns form ids which are unique and sequential within a
+// document. Forms are numbered in document order, as by ExtractForms, so
+// that forms skipped by the conversion do not shift the ids of the others.
+type formIDAllocator struct {
+	doc *html.Node
+	ids map[*html.Node]int // numbered on the first form
+}
+
+func (a *formIDAllocator) id(form *html.Node) int {
+	if a.ids == nil {
+		a.ids = numberForms(a.doc)
+	}
+	return a.ids[form]
+}
+
+// numberForms numbers the forms of doc from 1 in document order.
+func numberForms(doc *html.Node) map[*html.Node]int {
+	ids := map[*html.Node]int{}
+	var visit func(node *html.Node)
+	visit = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.DataAtom == atom.Form {
+			ids[node] = len(ids) + 1
+		}
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(doc)
+	return ids
 }
 
 // tableTraverseContext holds table ASCII-form related context.
@@ -160,42 +621,158 @@ type tableTraverseContext struct {
 	footer     []string
 	tmpRow     int
 	isInFooter bool
+	thRow      *html.Node // the first row if it has the only th cells of the table, see thHeaderRow
+	headerRow  *html.Node // the row rendered as header; further header rows are bold body rows
+	cells      int        // cells collected so far
+	truncated  bool       // whether cells were dropped for exceeding PrettyTablesOptions.MaxCells
+}
+
+// appendHeaderCell appends the rendered cell of a header row to the header
+// if the row is the first header row, and as a bold cell to the body
+// otherwise, as Org tables have a single header row.
+func (tableCtx *tableTraverseContext) appendHeaderCell(cell *html.Node, res string) {
+	if tableCtx.headerRow == nil {
+		tableCtx.headerRow = cell.Parent
+	}
+	if cell.Parent == tableCtx.headerRow {
+		tableCtx.header = append(tableCtx.header, res)
+		return
+	}
+	if res != "" {
+		res = "*" + res + "*"
+	}
+	tableCtx.body[tableCtx.tmpRow] = append(tableCtx.body[tableCtx.tmpRow], res)
 }
 
-func (tableCtx *tableTraverseContext) init() {
-	tableCtx.body = [][]string{}
-	tableCtx.header = []string{}
-	tableCtx.footer = []string{}
-	tableCtx.isInFooter = false
-	tableCtx.tmpRow = 0
+func newTableTraverseContext() *tableTraverseContext {
+	return &tableTraverseContext{
+		body:   [][]string{},
+		header: []string{},
+		footer: []string{},
+	}
 }
 
-func (ctx *textifyTraverseContext) traverseWithSubContext(node *html.Node) (textifyTraverseContext, error) {
-	subCtx := textifyTraverseContext{
-		options:        ctx.options,
-		fragmentIDs:    ctx.fragmentIDs,
-		isPreFormatted: ctx.isPreFormatted,
-		isInForm:       ctx.isInForm,
-		formCounter:    ctx.formCounter,
+// pushTable starts collecting a new table, stacked over any enclosing one.
+func (ctx *textifyTraverseContext) pushTable() {
+	ctx.tableCtxs = append(ctx.tableCtxs, newTableTraverseContext())
+}
+
+func (ctx *textifyTraverseContext) popTable() {
+	ctx.tableCtxs = ctx.tableCtxs[:len(ctx.tableCtxs)-1]
+}
+
+// tableCtx returns the innermost table being collected.
+func (ctx *textifyTraverseContext) tableCtx() *tableTraverseContext {
+	if len(ctx.tableCtxs) == 0 {
+		// rows rendered without their table element
+		ctx.pushTable()
 	}
+	return ctx.tableCtxs[len(ctx.tableCtxs)-1]
+}
+
+func (ctx *textifyTraverseContext) traverseWithSubContext(node *html.Node) (textifyTraverseContext, error) {
+	subCtx := ctx.newSubContext()
 	err := subCtx.traverseChildren(node)
 	return subCtx, err
 }
 
+// newSubContext creates a context sharing the document-wide state of ctx with an empty buffer.
+func (ctx *textifyTraverseContext) newSubContext() textifyTraverseContext {
+	return textifyTraverseContext{
+		options:         ctx.options,
+		fragmentIDs:     ctx.fragmentIDs,
+		isPreFormatted:  ctx.isPreFormatted,
+		isInVerse:       ctx.isInVerse,
+		isInTableCell:   ctx.isInTableCell,
+		isInLinkLabel:   ctx.isInLinkLabel,
+		isVerbatim:      ctx.isVerbatim,
+		isInForm:        ctx.isInForm,
+		formID:          ctx.formID,
+		forms:           ctx.forms,
+		stats:           ctx.stats,
+		headings:        ctx.headings,
+		progress:        ctx.progress,
+		footnotes:       ctx.footnotes,
+		isInFootnote:    ctx.isInFootnote,
+		dropSelectors:   ctx.dropSelectors,
+		lastOrderedList: ctx.lastOrderedList,
+		paragraphMark:   ctx.paragraphMark,
+	}
+}
+
 func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 	ctx.justClosedDiv = false
 
+	for _, sel := range ctx.dropSelectors {
+		if sel.matches(node) {
+			return nil
+		}
+	}
+
+	if ctx.options.FlattenSpans && isStylingSpan(node) {
+		// editor-injected wrapper: render the children as if the span did not exist
+		return ctx.traverseChildren(node)
+	}
+
+	if ctx.footnotes != nil {
+		if label, ok := ctx.footnotes.refs[node]; ok {
+			return ctx.emit("[fn:" + label + "]")
+		}
+		if _, ok := ctx.footnotes.defs[node]; ok {
+			// rendered as a definition at the end
+			return nil
+		}
+	}
+
+	if ctx.options.ContactCards && isContactCard(node) {
+		if card := contactCard(node); card != "" {
+			if err := ctx.emit("\n\n" + card + "\n\n"); err != nil {
+				return err
+			}
+			// the rest of the card, e.g. a bio, follows the list
+			return ctx.paragraphHandler(node)
+		}
+	} else if ctx.options.ContactCards && isContactField(node) {
+		return nil
+	}
+
+	if node != ctx.continuation && ctx.isAfterListItem && isListContinuation(node) {
+		return ctx.handleListContinuation(node)
+	}
+
+	if ctx.isRawPassthrough(node) {
+		return ctx.handleRawPassthrough(node)
+	}
+
+	if hasAnyClass(node, ctx.options.VerseClasses) {
+		return ctx.handleVerse(node)
+	}
+
+	if !ctx.isPreFormatted && isGenericContainer(node) && (ctx.options.HonorInlineStyles && hasPreWhiteSpaceStyle(node) ||
+		hasAnyClass(node, ctx.options.PreformattedClasses)) {
+		return ctx.handlePreStyled(node)
+	}
+
 	switch node.DataAtom {
 	case atom.Br:
+		if !ctx.isPreFormatted && isBreakAtBlockEdge(node) {
+			return nil
+		}
+		// plain table rows are single lines of space separated cells
+		if !ctx.isPreFormatted && !ctx.options.PrettyTables && !ctx.options.SimpleTables && isInTableCell(node) {
+			return ctx.emit(" ")
+		}
 		return ctx.emit("\n")
 
 	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
 		order := []atom.Atom{atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6}
 
 		var stars string
+		level := 0
 		for i, a := range order {
 			if node.DataAtom == a {
 				stars = strings.Repeat("*", i+1)
+				level = i + 1
 			}
 		}
 
@@ -204,31 +781,66 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 			return err
 		}
 
+		// a heading leading the blocks of a link becomes the headline of the
+		// link, which puts its number before the label and its drawer after
+		leadsLink := ctx.isInBlockLink && isBlank(ctx.buf.String())
 		str := strings.TrimSpace(cleanSpacing(subCtx.buf.String()))
+		if ctx.options.NumberHeadings {
+			if number := ctx.headings.number(level); leadsLink {
+				ctx.headingNumber = number
+			} else {
+				str = number + " " + str
+			}
+		}
+		ctx.stats.Headings++
+		ctx.headings.level = level
+		// only headlines have property drawers, which a heading nested in
+		// a list item, a table cell or a quote is not
+		if ctx.options.GenerateHeadingSlugs && !isInNestedBlock(node) && (leadsLink || !isInLink(node)) {
+			id := strings.TrimSpace(getAttrVal(node, "id"))
+			if id == "" {
+				id = ctx.headings.slug(textContent(node))
+			}
+			drawer := ":PROPERTIES:\n:CUSTOM_ID: " + id + "\n:END:\n"
+			if leadsLink {
+				ctx.headingDrawer = drawer
+			} else {
+				return ctx.emit("\n" + stars + " " + str + "\n" + drawer)
+			}
+		}
 		return ctx.emit("\n" + stars + " " + str + "\n")
 
 	case atom.Blockquote:
-		ctx.blockquoteLevel++
-		if err := ctx.emit("\n"); err != nil {
-			return err
+		name := ctx.calloutBlock(node)
+		if name == "" {
+			name = "quote"
 		}
-		if ctx.blockquoteLevel == 1 {
-			if err := ctx.emit("\n#+begin_quote\n"); err != nil {
-				return err
-			}
+		return ctx.handleQuoteBlock(node, name)
+
+	case atom.Q:
+		opening, closing := ctx.quoteMarks(node)
+		if err := ctx.emit(opening); err != nil {
+			return err
 		}
 		if err := ctx.traverseChildren(node); err != nil {
 			return err
 		}
-		if ctx.blockquoteLevel == 1 {
-			if err := ctx.emit("\n#+end_quote\n"); err != nil {
-				return err
-			}
+		if err := ctx.emit(closing); err != nil {
+			return err
 		}
-		ctx.blockquoteLevel--
-		return ctx.emit("\n\n")
+		cite, err := ctx.citeLink(node)
+		if err != nil || cite == "" {
+			return err
+		}
+		return ctx.emit(" (" + cite + ")")
 
 	case atom.Div:
+		if name := ctx.calloutBlock(node); name != "" {
+			return ctx.handleQuoteBlock(node, name)
+		}
+		if ctx.options.DivAsParagraph {
+			return ctx.paragraphHandler(node)
+		}
 		if ctx.lineLength > 0 {
 			if err := ctx.emit("\n"); err != nil {
 				return err
@@ -245,20 +857,53 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 		return err
 
 	case atom.Li:
+		ctx.isAfterListItem = true
+		n := 0
+		if ctx.orderedList != nil {
+			n = ctx.orderedList.itemNumber(node)
+		}
 		subCtx, err := ctx.traverseWithSubContext(node)
 		if err != nil {
 			return err
 		}
 		s := subCtx.buf.String()
-		cleaned := strings.TrimSpace(cleanSpacing(s))
-		if cleaned == "" {
+		if isBlank(s) {
+			// nothing but whitespace or empty inline elements
 			return nil
 		}
+		s = strings.Trim(s, " \n\r\t")
+		if checkbox := findListItemCheckbox(node); checkbox != nil {
+			if hasAttr(checkbox, "checked") {
+				s = "[X] " + s
+			} else {
+				s = "[ ] " + s
+			}
+		}
+		cookie := ""
+		if ctx.options.ChecklistStats {
+			if cookie = checklistCookie(node); cookie != "" {
+				if i := strings.Index(s, "\n"); i >= 0 {
+					s = s[:i] + " " + cookie + s[i:]
+				} else {
+					s += " " + cookie
+				}
+			}
+		}
 		ctx.prefix = "- "
+		ctx.itemIndent = 2
+		if ctx.orderedList != nil {
+			ctx.prefix = ctx.orderedList.bullet(n)
+			ctx.itemIndent = strings.Index(ctx.prefix, " ") + 1
+		}
+		if hasChildElement(node, atom.Details) || cookie != "" {
+			// the summary leads the item, the content of the details continues it;
+			// the checkboxes a cookie counts must be children of its item
+			s = indentContinuationLines(s, ctx.itemIndent)
+		}
 		if !ctx.endsWithNewLine {
 			ctx.emit("\n")
 		}
-		ctx.emit(strings.Trim(s, " \n\r\t"))
+		ctx.emit(s)
 		ctx.prefix = ""
 		return ctx.emit("\n")
 
@@ -284,40 +929,62 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 		return ctx.emit("\n")
 
 	case atom.B, atom.Strong:
+		if ctx.isPreFormatted && !ctx.isInVerse {
+			// markup is not rendered in src and example blocks
+			return ctx.traverseChildren(node)
+		}
 		subCtx, err := ctx.traverseWithSubContext(node)
 		if err != nil {
 			return nil
 		}
-		str := subCtx.buf.String()
-		return ctx.emit("*" + str + "*")
+		return ctx.emitEmphasis(node, subCtx.buf.String(), "*")
 
 	case atom.A:
+		if ctx.isInFootnote && strings.HasPrefix(getAttrVal(node, "href"), "#") {
+			// a link back to the reference
+			return nil
+		}
 		linkText := ""
+		headingDrawer := ""
 		// For simple link element content with single text node only, peek at the link text.
 		if node.FirstChild != nil && node.FirstChild.NextSibling == nil && node.FirstChild.Type == html.TextNode {
 			linkText = node.FirstChild.Data
 		}
 
 		// If image is the only child, take its alt text as the link text.
-		if img := node.FirstChild; img != nil && node.LastChild == img && img.DataAtom == atom.Img {
+		if img := node.FirstChild; img != nil && node.LastChild == img && img.DataAtom == atom.Img && !ctx.options.OmitImages {
 			if altText := getAttrVal(img, "alt"); altText != "" {
 				linkText = altText
-				if err := ctx.traverseChildren(node); err != nil {
-					return err
+				// in a table cell the link alone stands for the image
+				if !ctx.isInTableCell {
+					if err := ctx.traverseChildren(node); err != nil {
+						return err
+					}
 				}
 			}
 		} else if containsBlockLevelAtom(node) {
 			linkText = "Link"
-			subCtx, err := ctx.traverseWithSubContext(node)
-			if err != nil {
+			subCtx := ctx.newSubContext()
+			subCtx.isInBlockLink = true
+			if err := subCtx.traverseChildren(node); err != nil {
 				return err
 			}
+			rendered := subCtx.buf.String()
 			// make multiline to single line
-			s := cleanSpacing(subCtx.buf.String())
-			ctx.emit("\n" + strings.TrimPrefix(s, " "))
+			s := strings.TrimPrefix(cleanSpacing(rendered), " ")
+			if markup, label, ok := blockLinkLabel(node, rendered); ok {
+				s, linkText = markup, label
+			}
+			if number := subCtx.headingNumber; number != "" {
+				i := strings.Index(s, " ") + 1
+				s = s[:i] + number + " " + s[i:]
+			}
+			headingDrawer = subCtx.headingDrawer
+			ctx.emit("\n" + s)
 		} else {
-			subCtx, err := ctx.traverseWithSubContext(node)
-			if err != nil {
+			subCtx := ctx.newSubContext()
+			subCtx.isInLinkLabel = true
+			if err := subCtx.traverseChildren(node); err != nil {
 				return err
 			}
 			linkText = strings.TrimSpace(subCtx.buf.String())
@@ -325,17 +992,34 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 
 		hrefLink := ""
 		var err error
-		if !ctx.options.OmitLinks {
-			hrefLink, err = ctx.normalizeHrefLink(strings.TrimSpace(getAttrVal(node, "href")))
+		// href="" and href="#" (script-driven buttons) are no links:
+		// only the link text is rendered
+		if href := strings.TrimSpace(getAttrVal(node, "href")); !ctx.options.OmitLinks && href != "" && href != "#" && !hasAnyRel(node, ctx.options.DropLinkRels) {
+			baseURL := ctx.options.BaseURL
+			if ctx.options.KeepRelativeLinks {
+				baseURL = ""
+			}
+			hrefLink, err = ctx.normalizeLink(href, baseURL, ctx.options.KeepFragment)
 			if err != nil {
 				return err
 			}
 		}
 
+		if hrefLink != "" {
+			if strings.TrimSpace(linkText) == "" {
+				// icon links carry their label for screen readers
+				linkText = strings.TrimSpace(cleanSpacing(getAttrVal(node, "aria-label")))
+			}
+		}
+
+		if ctx.options.TransformLinkText != nil {
+			linkText = ctx.options.TransformLinkText(linkText, hrefLink)
+		}
+
 		res := ""
 		if linkText == "" && hrefLink == "" {
 			res = ""
-		} else if linkText == hrefLink {
+		} else if linkText == hrefLink && !ctx.options.AlwaysExplicitLinks {
 			res = fmt.Sprintf("[[%s]]", linkText)
 		} else if linkText != "" && hrefLink != "" {
 			res = fmt.Sprintf("[[%s][%s]]", hrefLink, linkText)
@@ -344,17 +1028,63 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 		} else if linkText != "" && hrefLink == "" {
 			res = fmt.Sprintf("%s", linkText)
 		}
+		if res != "" {
+			res += linkDataComment(node, ctx.options.CaptureLinkData)
+		}
 
-		return ctx.emit(res)
+		if ctx.options.DedupeAdjacentLinks && hrefLink != "" {
+			err = ctx.emitDedupedLink(hrefLink, linkText, res)
+		} else {
+			if hrefLink != "" {
+				ctx.stats.Links++
+			}
+			err = ctx.emit(res)
+		}
+		if err != nil || headingDrawer == "" {
+			return err
+		}
+		return ctx.emit("\n" + headingDrawer)
 
-	case atom.P, atom.Ul:
+	case atom.P:
 		return ctx.paragraphHandler(node)
 
+	case atom.Ul, atom.Ol:
+		orderedList, isAfterListItem := ctx.orderedList, ctx.isAfterListItem
+		ctx.orderedList, ctx.isAfterListItem = nil, false
+		if node.DataAtom == atom.Ol {
+			ctx.orderedList = newOrderedListContext(node)
+			if ctx.options.ContinueOrderedLists {
+				ctx.continueOrderedList(node)
+			}
+		}
+		err := ctx.paragraphHandler(node)
+		ctx.orderedList, ctx.isAfterListItem = orderedList, isAfterListItem
+		return err
+
 	case atom.Table, atom.Tfoot, atom.Th, atom.Tr, atom.Td:
-		if ctx.options.PrettyTables {
+		if node.DataAtom == atom.Table {
+			ctx.stats.Tables++
+		}
+		// tables nested in a cell are flattened, as a cell cannot hold a table
+		if (ctx.options.PrettyTables || ctx.options.SimpleTables) && !ctx.isInTableCell {
 			return ctx.handleTableElement(node)
+		} else if node.DataAtom == atom.Table && ctx.tableCaption != "" {
+			// the caption must directly precede the rows
+			if err := ctx.emit("\n\n" + ctx.takeTableCaption()); err != nil {
+				return err
+			}
+			if err := ctx.traverseChildren(node); err != nil {
+				return err
+			}
+			return ctx.emit("\n\n")
 		} else if node.DataAtom == atom.Table {
 			return ctx.paragraphHandler(node)
+		} else if node.DataAtom == atom.Th && isRowHeader(node) && !isHeaderRow(node.Parent) {
+			subCtx, err := ctx.traverseWithSubContext(node)
+			if err != nil {
+				return err
+			}
+			return ctx.emit(rowHeaderText(subCtx.buf.String()) + " ")
 		}
 
 		if err := ctx.traverseChildren(node); err != nil {
@@ -371,11 +1101,20 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 
 		return nil
 
-	case atom.Input:
-		t := getAttrVal(node, "type")
-		if t == "" {
-			t = "unknown"
+	case atom.Caption:
+		// rendered before the rows in both plain and pretty modes
+		subCtx, err := ctx.traverseWithSubContext(node)
+		if err != nil {
+			return err
+		}
+		caption := strings.TrimSpace(cleanSpacing(subCtx.buf.String()))
+		if caption == "" {
+			return nil
 		}
+		return ctx.emit(caption + "\n")
+
+	case atom.Input:
+		t := inputType(node)
 		value := getAttrVal(node, "value")
 		placeholder := getAttrVal(node, "placeholder")
 		content := ""
@@ -385,29 +1124,52 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 			content = placeholder
 		}
 
+		if _, ok := buttonInputTypes[t]; ok {
+			// a submit button inside a form labels the form's submit link
+			if t == "submit" && ctx.isInForm {
+				return nil
+			}
+			if value == "" && t == "submit" {
+				value = "Submit"
+			}
+			if value == "" {
+				return nil
+			}
+			return ctx.emit("[" + value + "]")
+		}
+
 		if _, ok := allowedInputTypes[t]; !ok {
 			return nil
 		}
 
+		if t == "range" {
+			// keep the bounds of the slider with its type
+			for _, attr := range []string{"min", "max", "value"} {
+				if v := getAttrVal(node, attr); v != "" {
+					t += fmt.Sprintf(" :%s %s", attr, v)
+				}
+			}
+		}
+
 		if !ctx.isInForm {
 
 			return ctx.emit(fmt.Sprintf(`
 
-#+begin_input _ :type %s
-%s
-#+end_input
+#+begin_%[1]s _ :type %[2]s
+%[3]s
+#+end_%[1]s
 
-`, t, content))
+`, ctx.inputBlockName(), t, content))
 
 		} else {
 			name := getAttrVal(node, "name")
-			id := fmt.Sprintf(orgFormIDFormat, ctx.formCounter)
+			id := fmt.Sprintf(orgFormIDFormat, ctx.formID)
 			return ctx.emit(fmt.Sprintf(`
 
-#+begin_input _ :type %s :id %s :name %s
-%s
-#+end_input
-`, t, id, name, content))
+#+begin_%[1]s _ :type %[2]s :id %[3]s :name %[4]s
+%[5]s
+#+end_%[1]s
+`, ctx.inputBlockName(), t, id, name, content))
 		}
 
 	case atom.Textarea:
@@ -426,59 +1188,119 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 		if !ctx.isInForm {
 			return ctx.emit(fmt.Sprintf(`
 
-#+begin_textarea _
-%s
-#+end_textarea
+#+begin_%[1]s _
+%[2]s
+#+end_%[1]s
 
-`, content))
+`, ctx.textareaBlockName(), content))
 		} else {
-			id := fmt.Sprintf(orgFormIDFormat, ctx.formCounter)
+			id := fmt.Sprintf(orgFormIDFormat, ctx.formID)
 			name := getAttrVal(node, "name")
 
 			return ctx.emit(fmt.Sprintf(`
 
-#+begin_textarea _ :id %s :name %s
+#+begin_%[1]s _ :id %[2]s :name %[3]s
+%[4]s
+#+end_%[1]s
+`, ctx.textareaBlockName(), id, name, content))
+		}
+
+	case atom.Output:
+		if !ctx.isInForm || isInTableCell(node) {
+			// a block would break the table row
+			return ctx.traverseChildren(node)
+		}
+		subCtx, err := ctx.traverseWithSubContext(node)
+		if err != nil {
+			return err
+		}
+		content := strings.TrimSpace(subCtx.buf.String())
+		id := fmt.Sprintf(orgFormIDFormat, ctx.formID)
+		name := getAttrVal(node, "name")
+
+		return ctx.emit(fmt.Sprintf(`
+
+#+begin_output _ :id %s :name %s
 %s
-#+end_textarea
+#+end_output
 `, id, name, content))
-		}
 
 	case atom.Form:
-		method := getAttrVal(node, "method")
+		method := formMethod(node)
 		action := getAttrVal(node, "action")
-		if method == "" {
-			method = "get"
-		}
 		if action == "" {
 			action = ctx.options.BaseURL
 		}
 		normalized, err := ctx.normalizeHrefLink(action)
-		ctx.isInForm = true
-		c := ctx.formCounter + 1
-		ctx.formCounter = c
-		id := fmt.Sprintf(orgFormIDFormat, c)
-		link := fmt.Sprintf("[[org-form:%s:%s:%s][Submit]]\n\n", id, method, normalized)
 		if err != nil {
 			return err
 		}
+		isInForm, formID := ctx.isInForm, ctx.formID
+		ctx.isInForm = true
+		ctx.formID = ctx.forms.id(node)
+		ctx.stats.Forms++
+		id := fmt.Sprintf(orgFormIDFormat, ctx.formID)
+		link := fmt.Sprintf("[[org-form:%s:%s:%s][%s]]\n\n", id, method, normalized, submitLabel(node))
 		err = ctx.traverseChildren(node)
 		ctx.emit(link)
-		ctx.isInForm = false
+		ctx.isInForm, ctx.formID = isInForm, formID
 		return err
 
 	case atom.Img:
-		alt := getAttrVal(node, "alt")
-		src, err := ctx.normalizeHrefLink(getAttrVal(node, "src"))
+		if ctx.options.OmitImages {
+			return nil
+		}
+		if ctx.options.DropLazyImages && strings.EqualFold(getAttrVal(node, "loading"), "lazy") {
+			return nil
+		}
+		alt := getAttrVal(node, "alt")
+		if alt == "" && hasAttr(node, "alt") {
+			alt = ctx.options.EmptyAltPlaceholder
+		}
+		if title := strings.TrimSpace(getAttrVal(node, "title")); ctx.options.ImageTitles && title != "" && title != alt {
+			if alt == "" {
+				alt = title
+			} else {
+				alt = fmt.Sprintf("%s (%s)", alt, title)
+			}
+		}
+		if ctx.isInLinkLabel {
+			return ctx.emit(cleanSpacing(alt))
+		}
+		src := getAttrVal(node, "src")
+		if source := ctx.preferredSource(node); source != "" {
+			src = source
+		}
+		src, err := ctx.normalizeHrefLink(src)
 		if err != nil {
 			return err
 		}
+		if ctx.options.LocalImagesAsFile {
+			src = toOrgFileLink(src)
+		}
 		if src == "" {
 			return ctx.emit("")
-		} else if alt != "" {
+		}
+		ctx.stats.Images++
+		if ctx.isInTableCell {
+			// caption lines would break the row
+			if alt != "" {
+				return ctx.emit(fmt.Sprintf("[[%s][%s]]", src, cleanSpacing(alt)))
+			}
+			return ctx.emit(fmt.Sprintf("[[%s]]", src))
+		}
+		attrs := ""
+		if width := imageWidth(node); ctx.options.ImageWidths && width > 0 {
+			attrs = fmt.Sprintf("#+ATTR_ORG: :width %d\n", width)
+		}
+		if alt != "" {
 			return ctx.emit(fmt.Sprintf(`
 #+CAPTION: %s
-[[%s]]
-`, alt, src))
+%s[[%s]]
+`, alt, attrs, src))
+		}
+		if attrs != "" {
+			return ctx.emit(fmt.Sprintf("\n%s[[%s]]\n", attrs, src))
 		}
 		return ctx.emit(fmt.Sprintf("[[%s]]\n", src))
 
@@ -487,8 +1309,22 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 			return ctx.traverseChildren(node)
 		}
 
+		if strings.TrimSpace(textContent(node)) == "" {
+			// whitespace only: emit an empty block rather than blank lines
+			if ctx.options.SkipEmptyPre {
+				return nil
+			}
+			ctx.stats.CodeBlocks++
+			return ctx.emit("\n#+begin_src\n#+end_src\n")
+		}
+
+		if ctx.options.PreKeepMarkup && !isCodePre(node) {
+			return ctx.handleVerse(node)
+		}
+
 		ctx.isPreFormatted = true
-		ctx.emit("\n#+begin_src\n")
+		ctx.stats.CodeBlocks++
+		ctx.emit("\n#+begin_src" + srcLanguage(node) + "\n")
 		err := ctx.traverseChildren(node)
 		if !ctx.endsWithNewLine {
 			ctx.emit("\n")
@@ -503,21 +1339,117 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 			return ctx.traverseChildren(node)
 		}
 
-		subCtx, err := ctx.traverseWithSubContext(node)
-		if err != nil {
+		// code with a data-lang attribute spanning lines is a block whose
+		// newlines are significant, as in a pre element
+		isBlock := hasAttr(node, "data-lang") && strings.Contains(strings.TrimSpace(textContent(node)), "\n")
+		subCtx := ctx.newSubContext()
+		subCtx.isPreFormatted = isBlock
+		if err := subCtx.traverseChildren(node); err != nil {
 			return err
 		}
 
 		result := strings.TrimSpace(subCtx.buf.String())
+		if isBlock {
+			// keep the indentation of the first line
+			result = strings.TrimRight(strings.TrimLeft(subCtx.buf.String(), "\n"), " \t\n")
+		} else if !containsLineBreak(node) {
+			// newlines from source formatting are not significant for inline code
+			result = strings.TrimSpace(cleanSpacing(result))
+		}
+		isOutput := ctx.options.SampAsOutput && node.DataAtom == atom.Samp
 		if strings.Contains(result, "\n") {
-			ctx.emit(fmt.Sprintf("\n#+begin_src\n%s\n#+end_src\n", result))
-		} else {
-			ctx.emit(fmt.Sprintf("~%s~", result))
+			ctx.stats.CodeBlocks++
+			if isOutput {
+				ctx.emit(fmt.Sprintf("\n#+begin_example\n%s\n#+end_example\n", result))
+			} else {
+				ctx.emit(fmt.Sprintf("\n#+begin_src%s\n%s\n#+end_src\n", srcLanguage(node), result))
+			}
+			return nil
+		}
+		// like emphasis, inline code needs boundaries to be fontified
+		if isOutput {
+			return ctx.emitEmphasis(node, result, "=")
 		}
+		return ctx.emitEmphasis(node, result, "~")
 
-		return nil
+	case atom.Details:
+		// a details element nested in a list item, a table cell or a quote
+		// cannot be a headline
+		if ctx.options.DetailsAsHeadline && !isInNestedBlock(node) {
+			return ctx.handleDetailsHeadline(node)
+		}
+		if !ctx.options.DetailsAsDrawer {
+			if node.Parent != nil && node.Parent.DataAtom == atom.Li {
+				// the summary starts a line of the item, as with the drawer
+				if err := ctx.emit("\n\n"); err != nil {
+					return err
+				}
+			}
+			return ctx.traverseChildren(node)
+		}
+		return ctx.handleDetailsDrawer(node)
+
+	case atom.Article:
+		if ctx.options.ArticleSeparator != "" && isTopLevelArticle(node) {
+			if prev := prevElementSibling(node); prev != nil && prev.DataAtom == atom.Article {
+				if err := ctx.emit("\n\n" + ctx.options.ArticleSeparator + "\n\n"); err != nil {
+					return err
+				}
+			}
+		}
+		return ctx.traverseChildren(node)
+
+	case atom.Hr:
+		style := ctx.options.HrStyle
+		if style == HrSmart {
+			style = HrRule
+			if separatesSimilarBlocks(node) {
+				style = HrBlankLine
+			}
+		}
+		switch style {
+		case HrBlankLine:
+			return ctx.emit("\n\n")
+		case HrAsterisks:
+			// the zero width space keeps the line from being parsed as a headline
+			return ctx.emit("\n\n#+begin_center\n\u200b* * *\n#+end_center\n\n")
+		default:
+			return ctx.emit("\n\n-----\n\n")
+		}
+
+	case atom.Bdi:
+		if !ctx.options.EmitBidiMarkers {
+			return ctx.traverseChildren(node)
+		}
+		if err := ctx.emit("\u2068"); err != nil {
+			return err
+		}
+		if err := ctx.traverseChildren(node); err != nil {
+			return err
+		}
+		return ctx.emit("\u2069")
+
+	case atom.Abbr, atom.Acronym:
+		if err := ctx.traverseChildren(node); err != nil {
+			return err
+		}
+		title := strings.TrimSpace(cleanSpacing(getAttrVal(node, "title")))
+		if !ctx.options.ExpandAbbreviations || title == "" {
+			return nil
+		}
+		return ctx.emit(" (" + title + ")")
+
+	case atom.Head:
+		if ctx.options.BodyOnly {
+			return nil
+		}
+		return ctx.traverseChildren(node)
 
 	case atom.Title:
+		if ctx.options.FrontMatter != FrontMatterNone {
+			// already in the front matter
+			return nil
+		}
 		ctx.emit("#+TITLE: ")
 		err := ctx.traverseChildren(node)
 		if err != nil {
@@ -535,6 +1467,40 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 		}
 		return nil
 
+	case atom.Figure:
+		figcaption := tableFigcaption(node)
+		if figcaption == nil {
+			return ctx.traverseChildren(node)
+		}
+		subCtx, err := ctx.traverseWithSubContext(figcaption)
+		if err != nil {
+			return err
+		}
+		// the table renderer emits the caption right above its rows
+		ctx.tableCaption = strings.TrimSpace(cleanSpacing(subCtx.buf.String()))
+		defer func() { ctx.tableCaption = "" }()
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			if c == figcaption {
+				continue
+			}
+			if err := ctx.traverse(c); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case atom.Wbr:
+		if ctx.options.WbrBreaks {
+			ctx.wbrAt = ctx.buf.Len()
+		}
+		return nil
+
+	case atom.Meter, atom.Progress:
+		if cookie := meterCookie(node); cookie != "" {
+			return ctx.emit(cookie)
+		}
+		return ctx.traverseChildren(node)
+
 	case atom.Style, atom.Script, atom.Meta, atom.Link:
 		// Ignore the subtree.
 		return nil
@@ -544,360 +1510,1629 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 	}
 }
 
-// paragraphHandler renders node children surrounded by double newlines.
-func (ctx *textifyTraverseContext) paragraphHandler(node *html.Node) error {
-	if err := ctx.emit("\n\n"); err != nil {
-		return err
+// tableFigcaption returns the figcaption of a figure holding a table, or nil
+// if the figure holds no table or no caption.
+func tableFigcaption(figure *html.Node) *html.Node {
+	var table, figcaption *html.Node
+	for c := figure.FirstChild; c != nil; c = c.NextSibling {
+		switch c.DataAtom {
+		case atom.Table:
+			table = c
+		case atom.Figcaption:
+			figcaption = c
+		}
 	}
-	if err := ctx.traverseChildren(node); err != nil {
-		return err
+	if table == nil || figcaption == nil || strings.TrimSpace(textContent(figcaption)) == "" {
+		return nil
 	}
-	return ctx.emit("\n\n")
+	return figcaption
 }
 
-// handleTableElement is only to be invoked when options.PrettyTables is active.
-func (ctx *textifyTraverseContext) handleTableElement(node *html.Node) error {
-	if !ctx.options.PrettyTables {
-		panic("handleTableElement invoked when PrettyTables not active")
+// takeTableCaption returns the pending figure caption as a #+CAPTION line,
+// so that only the first table of the figure gets it.
+func (ctx *textifyTraverseContext) takeTableCaption() string {
+	if ctx.tableCaption == "" {
+		return ""
 	}
+	caption := "#+CAPTION: " + ctx.tableCaption + "\n"
+	ctx.tableCaption = ""
+	return caption
+}
 
-	switch node.DataAtom {
-	case atom.Table:
-		if err := ctx.emit("\n\n"); err != nil {
-			return err
+// preferredSource returns the first image of the srcset of the source
+// element whose media matches options.PreferredMedia, when img is in a
+// picture element, or "" if there is none.
+func (ctx *textifyTraverseContext) preferredSource(img *html.Node) string {
+	if ctx.options.PreferredMedia == "" || img.Parent == nil || img.Parent.DataAtom != atom.Picture {
+		return ""
+	}
+	media := normalizeMedia(ctx.options.PreferredMedia)
+	for c := img.Parent.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom != atom.Source || normalizeMedia(getAttrVal(c, "media")) != media {
+			continue
 		}
-
-		// Re-intialize all table context.
-		ctx.tableCtx.init()
-
-		// Browse children, enriching context with table data.
-		if err := ctx.traverseChildren(node); err != nil {
-			return err
+		// "large.jpg 1x, large@2x.jpg 2x": the first candidate
+		candidate := strings.Fields(strings.Split(getAttrVal(c, "srcset"), ",")[0])
+		if len(candidate) > 0 {
+			return candidate[0]
 		}
+	}
+	return ""
+}
 
-		buf := &bytes.Buffer{}
-		table := tablewriter.NewWriter(buf)
-		var options *PrettyTablesOptions
-		if ctx.options.PrettyTablesOptions != nil {
-			options = ctx.options.PrettyTablesOptions
-		} else {
-			options = NewPrettyTablesOptions()
-		}
-		table.SetAutoFormatHeaders(options.AutoFormatHeader)
-		table.SetAutoWrapText(options.AutoWrapText)
-		table.SetReflowDuringAutoWrap(options.ReflowDuringAutoWrap)
-		table.SetColWidth(options.ColWidth)
-		table.SetColumnSeparator(options.ColumnSeparator)
-		table.SetRowSeparator(options.RowSeparator)
-		table.SetCenterSeparator(options.CenterSeparator)
-		table.SetHeaderAlignment(options.HeaderAlignment)
-		table.SetFooterAlignment(options.FooterAlignment)
-		table.SetAlignment(options.Alignment)
-		table.SetColumnAlignment(options.ColumnAlignment)
-		table.SetNewLine(options.NewLine)
-		table.SetHeaderLine(options.HeaderLine)
-		table.SetRowLine(options.RowLine)
-		table.SetAutoMergeCells(options.AutoMergeCells)
-		table.SetBorders(options.Borders)
+// normalizeMedia lowercases a media query and removes its spaces.
+func normalizeMedia(media string) string {
+	return strings.ToLower(strings.Join(strings.Fields(media), ""))
+}
 
-		table.SetHeader(ctx.tableCtx.header)
-		table.SetFooter(ctx.tableCtx.footer)
-		table.AppendBulk(ctx.tableCtx.body)
+// meterCookie renders the value of a meter or progress element like an Org
+// statistics cookie: "[70/100]", or "[70%]" when it has no max. It returns
+// "" if the element has no numeric value, e.g. an indeterminate progress.
+func meterCookie(node *html.Node) string {
+	value, err := strconv.ParseFloat(strings.TrimSpace(getAttrVal(node, "value")), 64)
+	if err != nil {
+		return ""
+	}
+	if max, err := strconv.ParseFloat(strings.TrimSpace(getAttrVal(node, "max")), 64); err == nil && max > 0 {
+		return fmt.Sprintf("[%s/%s]", strconv.FormatFloat(value, 'f', -1, 64), strconv.FormatFloat(max, 'f', -1, 64))
+	}
+	return fmt.Sprintf("[%.0f%%]", value*100)
+}
 
-		// Render the table using ASCII.
-		table.Render()
-		s := buf.String()
+// imageWidth returns the width in pixels set by the width attribute or
+// the width style of img, or 0 if it is not set in pixels.
+func imageWidth(img *html.Node) int {
+	for _, v := range []string{getAttrVal(img, "width"), getStyleVal(img, "width")} {
+		v = strings.TrimSuffix(strings.TrimSpace(v), "px")
+		if width, err := strconv.Atoi(v); err == nil && width > 0 {
+			return width
+		}
+	}
+	return 0
+}
 
-		if options.OrgFormat {
-			s = strings.TrimSuffix(s, "\n")
+// quoteMarks lists the outer and the nested quotation marks of locales.
+var quoteMarks = map[string][2][2]string{
+	"en": {{"“", "”"}, {"‘", "’"}},
+	"de": {{"„", "“"}, {"‚", "‘"}},
+	"fr": {{"« ", " »"}, {"“", "”"}},
+	"ja": {{"「", "」"}, {"『", "』"}},
+	"zh": {{"“", "”"}, {"‘", "’"}},
+}
 
-			// remove top, bottom boarders
-			// if options.Borders are used, footer format is invalid as org.
-			// thus delete here
-			centerSep := options.CenterSeparator
-			firstIndex := strings.Index(s, "\n")
-			lastIndex := strings.LastIndex(s, "\n")
+// quoteMarks returns the quotation marks of q as set by options.QuoteLocale,
+// alternating with the nesting level. No locale and locales not listed use
+// ASCII quotes.
+func (ctx *textifyTraverseContext) quoteMarks(q *html.Node) (string, string) {
+	nested := false
+	for p := q.Parent; p != nil; p = p.Parent {
+		if p.DataAtom == atom.Q {
+			nested = !nested
+		}
+	}
+	lang := strings.ToLower(strings.SplitN(strings.Replace(ctx.options.QuoteLocale, "_", "-", 1), "-", 2)[0])
+	marks, ok := quoteMarks[lang]
+	if !ok {
+		marks = [2][2]string{{`"`, `"`}, {"'", "'"}}
+	}
+	if nested {
+		return marks[1][0], marks[1][1]
+	}
+	return marks[0][0], marks[0][1]
+}
 
-			firstLine := s[0:firstIndex]
-			lastLine := s[lastIndex:]
+// isListContinuation reports whether node is content other than a list
+// item placed directly in a list, which is invalid but common. It continues
+// the previous item, if any. Headings, which cannot be part of an Org list
+// item, and elements which are not rendered are no continuations.
+func isListContinuation(node *html.Node) bool {
+	if node.Parent == nil || node.Parent.DataAtom != atom.Ul && node.Parent.DataAtom != atom.Ol {
+		return false
+	}
+	switch node.DataAtom {
+	case atom.Li, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Script, atom.Style, atom.Template:
+		return false
+	}
+	return true
+}
 
-			if strings.Contains(lastLine, centerSep) {
-				s = s[0:lastIndex]
-			}
-			if strings.Contains(firstLine, centerSep) {
-				s = s[firstIndex:]
-			}
+// handleListContinuation renders node as a continuation of the previous
+// list item, indented under its bullet.
+func (ctx *textifyTraverseContext) handleListContinuation(node *html.Node) error {
+	subCtx := ctx.newSubContext()
+	subCtx.continuation = node
+	if err := subCtx.traverse(node); err != nil {
+		return err
+	}
+	s := subCtx.buf.String()
+	if isBlank(s) {
+		return nil
+	}
+	if !ctx.endsWithNewLine {
+		ctx.emit("\n")
+	}
+	ctx.prefix = strings.Repeat(" ", ctx.itemIndent)
+	for _, line := range strings.Split(strings.Trim(s, " \n\r\t"), "\n") {
+		ctx.emit(line)
+		ctx.emit("\n")
+	}
+	ctx.prefix = ""
+	return nil
+}
 
-			// change center sep with ColumnSeparator on the left/right borders
-			s = strings.ReplaceAll(s, "\n+", "\n"+options.ColumnSeparator)
-			s = strings.ReplaceAll(s, "+\n", options.ColumnSeparator+"\n")
+func (ctx *textifyTraverseContext) isRawPassthrough(node *html.Node) bool {
+	for _, tag := range ctx.options.RawPassthroughTags {
+		if strings.EqualFold(node.Data, tag) {
+			return true
 		}
+	}
+	return false
+}
 
-		if err := ctx.emit(s); err != nil {
+// handleRawPassthrough renders the HTML of node as is in an export block.
+func (ctx *textifyTraverseContext) handleRawPassthrough(node *html.Node) error {
+	buf := &bytes.Buffer{}
+	if err := html.Render(buf, node); err != nil {
+		return err
+	}
+	return ctx.emit("\n#+begin_export html\n" + strings.TrimSpace(buf.String()) + "\n#+end_export\n")
+}
+
+// handleQuoteBlock renders node as a quote-like block named name, e.g.
+// "#+begin_quote". Blocks nested in it are flattened into the outer one.
+func (ctx *textifyTraverseContext) handleQuoteBlock(node *html.Node, name string) error {
+	ctx.blockquoteLevel++
+	if err := ctx.emit("\n"); err != nil {
+		return err
+	}
+	if ctx.blockquoteLevel == 1 {
+		if err := ctx.emit("\n#+begin_" + name + "\n"); err != nil {
 			return err
 		}
-
-		return ctx.emit("\n\n")
-
-	case atom.Tfoot:
-		ctx.tableCtx.isInFooter = true
-		if err := ctx.traverseChildren(node); err != nil {
+		ctx.justOpenedQuote = true
+	}
+	var attribution *html.Node
+	if ctx.options.QuoteAttribution && node.DataAtom == atom.Blockquote {
+		attribution = quoteAttribution(node)
+	}
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if c == attribution {
+			continue
+		}
+		if err := ctx.traverse(c); err != nil {
 			return err
 		}
-		ctx.tableCtx.isInFooter = false
-
-	case atom.Tr:
-		ctx.tableCtx.body = append(ctx.tableCtx.body, []string{})
-		if err := ctx.traverseChildren(node); err != nil {
+	}
+	if ctx.blockquoteLevel == 1 {
+		end := "\n#+end_" + name + "\n"
+		if ctx.quoteParaEnded {
+			// Paragraph padding must not leave a blank line before the end of the block.
+			b := ctx.buf.Bytes()
+			ctx.buf.Truncate(len(bytes.TrimRight(b, "\n")) + 1)
+			end = end[1:]
+		}
+		if err := ctx.emit(end); err != nil {
 			return err
 		}
-		ctx.tableCtx.tmpRow++
-
-	case atom.Th:
-		res, err := ctx.renderEachChild(node)
+	}
+	ctx.blockquoteLevel--
+	if attribution != nil {
+		subCtx, err := ctx.traverseWithSubContext(attribution)
 		if err != nil {
 			return err
 		}
-
-		ctx.tableCtx.header = append(ctx.tableCtx.header, res)
-
-	case atom.Td:
-		res, err := ctx.renderEachChild(node)
-		if err != nil {
+		author := strings.TrimLeft(strings.TrimSpace(cleanSpacing(subCtx.buf.String())), "—–- ")
+		if author != "" {
+			if err := ctx.emit("\n— " + author + "\n"); err != nil {
+				return err
+			}
+		}
+	}
+	if cite, err := ctx.citeLink(node); err != nil {
+		return err
+	} else if cite != "" {
+		if err := ctx.emit("\n-- " + cite + "\n"); err != nil {
 			return err
 		}
+	}
+	return ctx.emit("\n\n")
+}
 
-		if ctx.tableCtx.isInFooter {
-			ctx.tableCtx.footer = append(ctx.tableCtx.footer, res)
-		} else {
-			ctx.tableCtx.body[ctx.tableCtx.tmpRow] = append(ctx.tableCtx.body[ctx.tableCtx.tmpRow], res)
+// quoteAttribution returns the footer or cite child of a blockquote naming
+// the author of the quote, or nil if it has none.
+func quoteAttribution(blockquote *html.Node) *html.Node {
+	var cite *html.Node
+	for c := blockquote.FirstChild; c != nil; c = c.NextSibling {
+		switch c.DataAtom {
+		case atom.Footer:
+			return c
+		case atom.Cite:
+			if cite == nil {
+				cite = c
+			}
 		}
-
 	}
-	return nil
+	return cite
 }
 
-func (ctx *textifyTraverseContext) handleInternalLinks(node *html.Node) error {
-	if !ctx.options.InternalLinks {
-		return nil
+// calloutBlock returns the Org block name options.CalloutClassMap gives to
+// the first mapped class of node, or "" if none is mapped.
+func (ctx *textifyTraverseContext) calloutBlock(node *html.Node) string {
+	if len(ctx.options.CalloutClassMap) == 0 {
+		return ""
 	}
-	id := getAttrVal(node, "id")
-	name := getAttrVal(node, "name")
-	_, matchedID := ctx.fragmentIDs[id]
-	_, matchedName := ctx.fragmentIDs[name]
-	if matchedID || matchedName {
-		var frag string
-		if matchedID {
-			frag = id
-		} else {
-			frag = name
-		}
-		endsWithNewLine := ctx.endsWithNewLine
-		if endsWithNewLine {
-			b := ctx.buf.Bytes()
-			ctx.buf = *bytes.NewBuffer(b[0 : len(b)-1])
-			ctx.endsWithNewLine = false
-		}
-		if err := ctx.emit(" <<" + frag + ">> "); err != nil {
-			return err
-		}
-		if endsWithNewLine {
-			ctx.emit("\n")
+	for _, c := range strings.Fields(getAttrVal(node, "class")) {
+		if name, ok := ctx.options.CalloutClassMap[c]; ok && name != "" {
+			return name
 		}
 	}
-	return nil
+	return ""
 }
 
-func (ctx *textifyTraverseContext) traverse(node *html.Node) error {
-	switch node.Type {
-	default:
-		return ctx.traverseChildren(node)
+// handleVerse renders node as a verse block, which keeps line breaks but allows markup.
+func (ctx *textifyTraverseContext) handleVerse(node *html.Node) error {
+	isPreFormatted, isInVerse := ctx.isPreFormatted, ctx.isInVerse
+	if node.DataAtom == atom.Pre {
+		ctx.isPreFormatted = true
+	}
+	ctx.isInVerse = true
+	ctx.emit("\n#+begin_verse\n")
+	err := ctx.traverseChildren(node)
+	if !ctx.endsWithNewLine {
+		ctx.emit("\n")
+	}
+	ctx.emit("#+end_verse\n")
 
-	case html.TextNode:
-		var data string
-		if ctx.isPreFormatted {
-			data = node.Data
-		} else {
-			data = cleanSpacing(node.Data)
+	ctx.isPreFormatted, ctx.isInVerse = isPreFormatted, isInVerse
+	return err
+}
+
+// isGenericContainer reports whether node is a div, span or p element, the
+// only elements rendered as example blocks when styled as preformatted, as
+// the others have renderings of their own, e.g. links or table cells.
+func isGenericContainer(node *html.Node) bool {
+	return node.DataAtom == atom.Div || node.DataAtom == atom.Span || node.DataAtom == atom.P
+}
+
+// handlePreStyled renders an element styled with `white-space: pre` or
+// one of options.PreformattedClasses as an example block.
+func (ctx *textifyTraverseContext) handlePreStyled(node *html.Node) error {
+	ctx.isPreFormatted = true
+	ctx.stats.CodeBlocks++
+	ctx.emit("\n#+begin_example\n")
+	err := ctx.traverseChildren(node)
+	if !ctx.endsWithNewLine {
+		ctx.emit("\n")
+	}
+	ctx.emit("#+end_example\n")
+
+	ctx.isPreFormatted = false
+	return err
+}
+
+// handleDetailsDrawer renders the summary of a details element on its own line.
+// The rest of the content follows as is when the element is open, otherwise
+// inside a drawer which Org folds by default.
+func (ctx *textifyTraverseContext) handleDetailsDrawer(node *html.Node) error {
+	summary, content, err := ctx.detailsParts(node)
+	if err != nil {
+		return err
+	}
+
+	if hasAttr(node, "open") {
+		return ctx.emit("\n\n" + summary + "\n" + content + "\n\n")
+	}
+	return ctx.emit("\n\n" + summary + "\n:DETAILS:\n" + content + "\n:END:\n\n")
+}
+
+// handleDetailsHeadline renders a details element as a headline of its
+// summary one level below the current headline, with its content as the
+// body, e.g. for FAQs whose answers Org then folds. Content following the
+// details belongs to that headline as well, as Org has no end of subtrees.
+func (ctx *textifyTraverseContext) handleDetailsHeadline(node *html.Node) error {
+	level := ctx.headings.level
+	ctx.headings.level = level + 1
+	summary, content, err := ctx.detailsParts(node)
+	ctx.headings.level = level
+	if err != nil {
+		return err
+	}
+	if summary == "" {
+		summary = "Details"
+	}
+	return ctx.emit("\n\n" + strings.Repeat("*", level+1) + " " + summary + "\n" + content + "\n\n")
+}
+
+// detailsParts renders the summary of a details element on a single line
+// and the rest of its children as its content.
+func (ctx *textifyTraverseContext) detailsParts(node *html.Node) (summary, content string, err error) {
+	summaryCtx := ctx.newSubContext()
+	contentCtx := ctx.newSubContext()
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom == atom.Summary {
+			err = summaryCtx.traverseChildren(c)
+		} else {
+			err = contentCtx.traverse(c)
+		}
+		if err != nil {
+			return "", "", err
+		}
+	}
+	summary = strings.TrimSpace(cleanSpacing(summaryCtx.buf.String()))
+	content = strings.Trim(contentCtx.buf.String(), " \n\r\t")
+	return summary, content, nil
+}
+
+// citeLink returns the cite attribute of node as an Org link when options.EmitCite is active.
+func (ctx *textifyTraverseContext) citeLink(node *html.Node) (string, error) {
+	if !ctx.options.EmitCite {
+		return "", nil
+	}
+	cite, err := ctx.normalizeHrefLink(strings.TrimSpace(getAttrVal(node, "cite")))
+	if err != nil || cite == "" {
+		return "", err
+	}
+	return fmt.Sprintf("[[%s]]", cite), nil
+}
+
+// formMethod returns the method of form, "get" unless set.
+func formMethod(form *html.Node) string {
+	if method := getAttrVal(form, "method"); method != "" {
+		return method
+	}
+	return "get"
+}
+
+// inputType returns the type of input, "unknown" unless set.
+func inputType(input *html.Node) string {
+	if t := getAttrVal(input, "type"); t != "" {
+		return t
+	}
+	return "unknown"
+}
+
+// submitLabel returns the value of the first submit input in form, or "Submit".
+func submitLabel(form *html.Node) string {
+	if input := findSubmitInput(form); input != nil {
+		if value := strings.TrimSpace(getAttrVal(input, "value")); value != "" {
+			return value
+		}
+	}
+	return "Submit"
+}
+
+func findSubmitInput(node *html.Node) *html.Node {
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom == atom.Input && getAttrVal(c, "type") == "submit" {
+			return c
+		}
+		if input := findSubmitInput(c); input != nil {
+			return input
+		}
+	}
+	return nil
+}
+
+// findListItemCheckbox returns the checkbox of a task list item, ignoring nested lists.
+func findListItemCheckbox(node *html.Node) *html.Node {
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom == atom.Ul || c.DataAtom == atom.Ol {
+			continue
+		}
+		if c.DataAtom == atom.Input && strings.EqualFold(getAttrVal(c, "type"), "checkbox") {
+			return c
+		}
+		if checkbox := findListItemCheckbox(c); checkbox != nil {
+			return checkbox
+		}
+	}
+	return nil
+}
+
+// checklistCookie counts the checked items of the lists directly under li and
+// returns them as an Org statistics cookie, or "" if there are no checkboxes.
+func checklistCookie(li *html.Node) string {
+	var checked, total int
+	for list := li.FirstChild; list != nil; list = list.NextSibling {
+		if list.DataAtom != atom.Ul && list.DataAtom != atom.Ol {
+			continue
+		}
+		for item := list.FirstChild; item != nil; item = item.NextSibling {
+			if item.DataAtom != atom.Li {
+				continue
+			}
+			if checkbox := findListItemCheckbox(item); checkbox != nil {
+				total++
+				if hasAttr(checkbox, "checked") {
+					checked++
+				}
+			}
+		}
+	}
+	if total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("[%d/%d]", checked, total)
+}
+
+// emitDedupedLink emits link unless the last link emitted has the same href
+// and only spaces were written since. Then the link with the longer label
+// is kept.
+func (ctx *textifyTraverseContext) emitDedupedLink(href, label, link string) error {
+	if last := ctx.lastLink; last != nil && last.href == href && last.end <= ctx.buf.Len() &&
+		strings.Trim(string(ctx.buf.Bytes()[last.end:]), " ") == "" {
+		if utf8.RuneCountInString(label) <= utf8.RuneCountInString(last.label) {
+			ctx.truncate(last.end)
+			return nil
+		}
+		// replaces the last link, which has been counted
+		ctx.truncate(last.start)
+	} else {
+		ctx.stats.Links++
+	}
+	start := ctx.buf.Len()
+	if err := ctx.emit(link); err != nil {
+		return err
+	}
+	ctx.lastLink = &emittedLink{href: href, label: label, start: start, end: ctx.buf.Len()}
+	return nil
+}
+
+// truncate discards the buffer from n on, keeping the line state in sync.
+func (ctx *textifyTraverseContext) truncate(n int) {
+	ctx.buf.Truncate(n)
+	b := ctx.buf.Bytes()
+	ctx.endsWithNewLine = bytes.HasSuffix(b, []byte("\n"))
+	ctx.lineLength = runewidth.StringWidth(string(b[bytes.LastIndexByte(b, '\n')+1:]))
+}
+
+// emptyParagraphMark returns the character standing for an empty paragraph
+// kept by options.KeepEmptyParagraphs until postProcess turns it into a blank
+// line: the first private use character that the text and the attributes of
+// doc do not contain, or "" if there is none.
+func emptyParagraphMark(doc *html.Node) string {
+	used := map[rune]bool{}
+	collect := func(s string) {
+		for _, r := range s {
+			if r >= '\ue000' && r <= '\uf8ff' {
+				used[r] = true
+			}
+		}
+	}
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		collect(node.Data)
+		for _, attr := range node.Attr {
+			collect(attr.Val)
+		}
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	for r := '\ue000'; r <= '\uf8ff'; r++ {
+		if !used[r] {
+			return string(r)
+		}
+	}
+	return ""
+}
+
+// maxConsecutiveBlankLines bounds the blank lines kept by
+// options.KeepEmptyParagraphs.
+const maxConsecutiveBlankLines = 3
+
+// paragraphHandler renders node children surrounded by double newlines.
+func (ctx *textifyTraverseContext) paragraphHandler(node *html.Node) error {
+	if ctx.paragraphMark != "" && node.DataAtom == atom.P && !ctx.isInTableCell && isEmptyParagraph(node) {
+		return ctx.emit("\n\n" + ctx.paragraphMark + "\n\n")
+	}
+	if isImageOnly(node) {
+		return ctx.imageParagraph(node)
+	}
+	if !ctx.justOpenedQuote {
+		if err := ctx.emit("\n\n"); err != nil {
+			return err
+		}
+	}
+	if err := ctx.traverseChildren(node); err != nil {
+		return err
+	}
+	if err := ctx.emit("\n\n"); err != nil {
+		return err
+	}
+	ctx.quoteParaEnded = ctx.blockquoteLevel > 0
+	return nil
+}
+
+// imageParagraph renders a paragraph holding nothing but an image as the
+// image block alone, separated from its neighbours by exactly one blank line
+// instead of stacking the paragraph's newlines on the block's own.
+func (ctx *textifyTraverseContext) imageParagraph(node *html.Node) error {
+	subCtx := ctx.newSubContext()
+	if err := subCtx.traverseChildren(node); err != nil {
+		return err
+	}
+	block := strings.TrimSpace(subCtx.buf.String())
+	if block == "" {
+		return nil
+	}
+	if !ctx.justOpenedQuote {
+		block = "\n\n" + block
+	}
+	if err := ctx.emit(block + "\n\n"); err != nil {
+		return err
+	}
+	ctx.quoteParaEnded = ctx.blockquoteLevel > 0
+	return nil
+}
+
+// isEmptyParagraph reports whether node holds nothing but whitespace and
+// line breaks.
+func isEmptyParagraph(node *html.Node) bool {
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == html.TextNode && isBlank(c.Data), c.Type == html.CommentNode, c.DataAtom == atom.Br:
+		case c.Type == html.ElementNode && c.DataAtom != atom.Img && isEmptyParagraph(c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isImageOnly reports whether the only significant child of node is an img.
+func isImageOnly(node *html.Node) bool {
+	images := 0
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == html.TextNode && isBlank(c.Data), c.Type == html.CommentNode:
+		case c.DataAtom == atom.Img:
+			images++
+		default:
+			return false
+		}
+	}
+	return images == 1
+}
+
+// handleTableElement is only to be invoked when options.PrettyTables or
+// options.SimpleTables is active.
+func (ctx *textifyTraverseContext) handleTableElement(node *html.Node) error {
+	if !ctx.options.PrettyTables && !ctx.options.SimpleTables {
+		panic("handleTableElement invoked when PrettyTables and SimpleTables not active")
+	}
+
+	switch node.DataAtom {
+	case atom.Table:
+		if err := ctx.emit("\n\n" + ctx.takeTableCaption()); err != nil {
+			return err
+		}
+
+		ctx.pushTable()
+		defer ctx.popTable()
+		tableCtx := ctx.tableCtx()
+		tableCtx.thRow = thHeaderRow(node)
+
+		// Browse children, enriching context with table data.
+		if err := ctx.traverseChildren(node); err != nil {
+			return err
+		}
+
+		if !ctx.options.PrettyTables {
+			if err := ctx.emit(renderSimpleTable(tableCtx)); err != nil {
+				return err
+			}
+			return ctx.emit(truncationNote(tableCtx) + "\n\n")
+		}
+
+		buf := &bytes.Buffer{}
+		table := tablewriter.NewWriter(buf)
+		var options *PrettyTablesOptions
+		if ctx.options.PrettyTablesOptions != nil {
+			options = ctx.options.PrettyTablesOptions
+		} else {
+			options = NewPrettyTablesOptions()
+		}
+		table.SetAutoFormatHeaders(options.AutoFormatHeader)
+		table.SetAutoWrapText(options.AutoWrapText)
+		table.SetReflowDuringAutoWrap(options.ReflowDuringAutoWrap)
+		table.SetColWidth(options.ColWidth)
+		table.SetColumnSeparator(options.ColumnSeparator)
+		table.SetRowSeparator(options.RowSeparator)
+		table.SetCenterSeparator(options.CenterSeparator)
+		table.SetHeaderAlignment(options.HeaderAlignment)
+		table.SetFooterAlignment(options.FooterAlignment)
+		table.SetAlignment(options.Alignment)
+		table.SetColumnAlignment(options.ColumnAlignment)
+		table.SetNewLine(options.NewLine)
+		table.SetHeaderLine(options.HeaderLine)
+		table.SetRowLine(options.RowLine)
+		table.SetAutoMergeCells(options.AutoMergeCells)
+		table.SetBorders(options.Borders)
+
+		header, body, footer := tableCtx.header, nonEmptyRows(tableCtx.body), tableCtx.footer
+		if options.Transform != nil {
+			header, body, footer = options.Transform(header, body, footer)
+		}
+		var kept []int // indices of the columns left, nil if all are
+		if options.DropEmptyColumns {
+			kept = nonEmptyColumns(append([][]string{header, footer}, body...))
+			header, footer = selectColumns(header, kept), selectColumns(footer, kept)
+			rows := make([][]string, len(body))
+			for i, row := range body {
+				rows[i] = selectColumns(row, kept)
+			}
+			body = rows
+		}
+		if options.ForceHeaderSeparator && len(header) == 0 && len(body) > 0 {
+			// render the first row as is, only separated like a header
+			header, body = body[0], body[1:]
+			table.SetAutoFormatHeaders(false)
+			table.SetHeaderAlignment(options.Alignment)
+		}
+		if len(body) == 0 {
+			if len(header) == 0 {
+				// a lone footer is rendered as plain row
+				body, footer = [][]string{footer}, []string{}
+			} else {
+				// nothing to separate the header from
+				table.SetHeaderLine(false)
+			}
+		}
+
+		if len(options.ColumnAlignment) == 0 {
+			if alignments := colAlignments(node); len(alignments) > 0 {
+				if kept != nil {
+					keptAlignments := []int{}
+					for _, i := range kept {
+						if i < len(alignments) {
+							keptAlignments = append(keptAlignments, alignments[i])
+						}
+					}
+					alignments = keptAlignments
+				}
+				// tablewriter ignores alignments not covering every column
+				columns := len(header)
+				if len(footer) > columns {
+					columns = len(footer)
+				}
+				for _, row := range body {
+					if len(row) > columns {
+						columns = len(row)
+					}
+				}
+				for len(alignments) < columns {
+					alignments = append(alignments, tablewriter.ALIGN_DEFAULT)
+				}
+				table.SetColumnAlignment(alignments)
+			}
+		}
+
+		table.SetHeader(header)
+		table.SetFooter(footer)
+		table.AppendBulk(body)
+
+		// Render the table using ASCII.
+		table.Render()
+		s := buf.String()
+
+		if options.OrgFormat {
+			s = strings.TrimSuffix(s, "\n")
+
+			// remove top, bottom boarders
+			// if options.Borders are used, footer format is invalid as org.
+			// thus delete here
+			if firstIndex := strings.Index(s, "\n"); firstIndex >= 0 {
+				lastIndex := strings.LastIndex(s, "\n")
+
+				firstLine := s[0:firstIndex]
+				lastLine := s[lastIndex+1:]
+
+				if isTableRule(lastLine, options) {
+					s = s[0:lastIndex]
+				}
+				if isTableRule(firstLine, options) {
+					s = s[firstIndex:]
+				}
+			}
+
+			// rules between rows must span every column to be Org hlines,
+			// which rules beside merged cells do not
+			lines := strings.Split(s, "\n")
+			for i, line := range lines {
+				if isTableRule(line, options) {
+					lines[i] = strings.Replace(line, " ", options.RowSeparator, -1)
+				}
+			}
+			s = strings.Join(lines, "\n")
+
+			// change center sep with ColumnSeparator on the left/right borders
+			s = strings.ReplaceAll(s, "\n+", "\n"+options.ColumnSeparator)
+			s = strings.ReplaceAll(s, "+\n", options.ColumnSeparator+"\n")
+		}
+
+		// the table starts right after preceding text such as a caption
+		s = strings.TrimPrefix(s, "\n")
+		if err := ctx.emit(s); err != nil {
+			return err
+		}
+
+		return ctx.emit(truncationNote(tableCtx) + "\n\n")
+
+	case atom.Tfoot:
+		tableCtx := ctx.tableCtx()
+		tableCtx.isInFooter = true
+		if err := ctx.traverseChildren(node); err != nil {
+			return err
+		}
+		tableCtx.isInFooter = false
+
+	case atom.Tr:
+		tableCtx := ctx.tableCtx()
+		if tableCtx.truncated {
+			return nil
+		}
+		tableCtx.body = append(tableCtx.body, []string{})
+		if err := ctx.traverseChildren(node); err != nil {
+			return err
+		}
+		tableCtx.tmpRow++
+
+	case atom.Th:
+		if !ctx.collectCell() {
+			return nil
+		}
+		res, err := ctx.renderCell(node)
+		if err != nil {
+			return err
+		}
+
+		tableCtx := ctx.tableCtx()
+		if !isRowHeader(node) || tableCtx.isHeaderRow(node.Parent) {
+			tableCtx.appendHeaderCell(node, res)
+		} else if tableCtx.isInFooter {
+			tableCtx.footer = append(tableCtx.footer, rowHeaderText(res))
+		} else {
+			tableCtx.body[tableCtx.tmpRow] = append(tableCtx.body[tableCtx.tmpRow], rowHeaderText(res))
+		}
+
+	case atom.Td:
+		if !ctx.collectCell() {
+			return nil
+		}
+		res, err := ctx.renderCell(node)
+		if err != nil {
+			return err
+		}
+
+		tableCtx := ctx.tableCtx()
+		if tableCtx.isInFooter {
+			tableCtx.footer = append(tableCtx.footer, res)
+		} else if tableCtx.isHeaderRow(node.Parent) {
+			tableCtx.appendHeaderCell(node, res)
+		} else {
+			tableCtx.body[tableCtx.tmpRow] = append(tableCtx.body[tableCtx.tmpRow], res)
+		}
+
+	}
+	return nil
+}
+
+// collectCell counts a cell of the current table and reports whether it
+// is within PrettyTablesOptions.MaxCells, so that huge tables of untrusted
+// documents do not exhaust memory.
+func (ctx *textifyTraverseContext) collectCell() bool {
+	tableCtx := ctx.tableCtx()
+	if options := ctx.options.PrettyTablesOptions; options != nil && options.MaxCells > 0 && tableCtx.cells >= options.MaxCells {
+		tableCtx.truncated = true
+	}
+	if tableCtx.truncated {
+		return false
+	}
+	tableCtx.cells++
+	return true
+}
+
+// truncationNote returns the line noting that cells of a table were
+// dropped, or "" if none were.
+func truncationNote(tableCtx *tableTraverseContext) string {
+	if !tableCtx.truncated {
+		return ""
+	}
+	return fmt.Sprintf("\n(table truncated after %d cells)", tableCtx.cells)
+}
+
+func (ctx *textifyTraverseContext) handleInternalLinks(node *html.Node) error {
+	if !ctx.options.InternalLinks {
+		return nil
+	}
+	id := getAttrVal(node, "id")
+	name := getAttrVal(node, "name")
+	_, matchedID := ctx.fragmentIDs[id]
+	_, matchedName := ctx.fragmentIDs[name]
+	if matchedID || matchedName {
+		var frag string
+		if matchedID {
+			frag = id
+		} else {
+			frag = name
+		}
+		endsWithNewLine := ctx.endsWithNewLine
+		if endsWithNewLine {
+			b := ctx.buf.Bytes()
+			ctx.buf = *bytes.NewBuffer(b[0 : len(b)-1])
+			ctx.endsWithNewLine = false
+		}
+		if err := ctx.emit(" <<" + frag + ">> "); err != nil {
+			return err
+		}
+		if endsWithNewLine {
+			ctx.emit("\n")
+		}
+	}
+	return nil
+}
+
+func (ctx *textifyTraverseContext) traverse(node *html.Node) error {
+	ctx.progress.visit()
+	switch node.Type {
+	default:
+		return ctx.traverseChildren(node)
+
+	case html.TextNode:
+		var data string
+		if ctx.isPreFormatted {
+			data = node.Data
+			if ctx.options.PreCollapseSpaces {
+				data = collapsePreSpaces(data, ctx.endsWithNewLine || ctx.buf.Len() == 0)
+			}
+		} else {
+			if ctx.options.PreserveSoftBreaks && !ctx.isInTableCell && isInParagraph(node) {
+				data = cleanSpacingKeepingBreaks(node)
+			} else {
+				data = cleanSpacing(node.Data)
+			}
+			// byte order marks of concatenated documents are never text
+			data = byteOrderMarkReplacer.Replace(data)
+			if ctx.options.AsciiPunctuation && !ctx.isVerbatim {
+				data = asciiPunctuationReplacer.Replace(data)
+			}
+			if ctx.options.AutoLinkify && !isInLinkOrCode(node) {
+				var links int
+				data, links = linkify(data)
+				ctx.stats.Links += links
+			}
+		}
+		return ctx.emit(data)
+
+	case html.ElementNode:
+		isVerbatim := ctx.isVerbatim
+		switch strings.ToLower(getAttrVal(node, "translate")) {
+		case "no":
+			ctx.isVerbatim = true
+		case "yes":
+			ctx.isVerbatim = false
+		}
+		err := ctx.handleElement(node)
+		ctx.isVerbatim = isVerbatim
+		if err != nil {
+			return err
+		}
+
+		return ctx.handleInternalLinks(node)
+	}
+
+}
+
+func (ctx *textifyTraverseContext) traverseChildren(node *html.Node) error {
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if err := ctx.traverse(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ctx *textifyTraverseContext) emit(data string) error {
+	if data == "" {
+		return nil
+	}
+	var (
+		lines = ctx.breakLongLines(data)
+		// lines = strings.Split(data, "\n") TODO
+		err error
+	)
+	for _, line := range lines {
+		runes := []rune(line)
+
+		if !ctx.isPreFormatted && ctx.endsWithNewLine {
+			line = strings.TrimPrefix(line, " ")
+			if ctx.prefix != "" {
+				ctx.endsWithNewLine = false
+				if _, err = ctx.buf.WriteString(ctx.prefix); err != nil {
+					return err
+				}
+			}
+		}
+
+		if line != "" {
+			ctx.endsWithNewLine = runes[len(runes)-1] == '\n'
+			ctx.justOpenedQuote = false
+			ctx.quoteParaEnded = false
+		}
+
+		for _, c := range line {
+			if _, err = ctx.buf.WriteString(string(c)); err != nil {
+				return err
+			}
+			ctx.lineLength += runewidth.RuneWidth(c)
+			if c == '\n' {
+				ctx.lineLength = 0
+			}
+		}
+	}
+	return nil
+}
+
+const defaultMaxLineLen = 74
+
+func (ctx *textifyTraverseContext) maxLineLen() int {
+	if ctx.options.MaxLineLen > 0 {
+		return ctx.options.MaxLineLen
+	}
+	return defaultMaxLineLen
+}
+
+// breakLongLines splits data so that no line exceeds the max line length.
+// Widths are counted in columns, thus wide runes (e.g. CJK) count as two.
+func (ctx *textifyTraverseContext) breakLongLines(data string) []string {
+	// Only break lines when in blockquotes.
+	if ctx.blockquoteLevel == 0 || !ctx.options.BreakLongLines {
+		return []string{data}
+	}
+	var (
+		ret      = []string{}
+		maxLen   = ctx.maxLineLen()
+		existing = ctx.lineLength
+	)
+	for _, segment := range strings.SplitAfter(data, "\n") {
+		runes := []rune(segment)
+		for {
+			line := strings.TrimSuffix(string(runes), "\n")
+			if line == "" || existing+runewidth.StringWidth(line) <= maxLen {
+				break
+			}
+			// runes[fit] is the first rune which does not fit in the line
+			fit, width := 0, existing
+			for width+runewidth.RuneWidth(runes[fit]) <= maxLen {
+				width += runewidth.RuneWidth(runes[fit])
+				fit++
+			}
+			i := fit
+			for i >= 0 && !isBreakSpace(runes[i]) {
+				i--
+			}
+			if i <= 0 && existing > 0 && len(ret) == 0 && ctx.breakAtWbr() {
+				existing = ctx.lineLength
+				continue
+			}
+			if i <= 0 && existing > 0 {
+				// the first word does not fit in the rest of the line
+				ret = append(ret, "\n")
+				runes = trimBreakSpaces(runes)
+				existing = 0
+				continue
+			}
+			if i == 0 {
+				runes = trimBreakSpaces(runes)
+				continue
+			}
+			if i < 0 {
+				// no space to break at
+				if fit > 0 && (runewidth.RuneWidth(runes[fit]) > 1 || runewidth.RuneWidth(runes[fit-1]) > 1) {
+					// wide runes may be broken anywhere
+					i = fit
+				} else {
+					i = fit
+					for i < len(runes) && !isBreakSpace(runes[i]) {
+						i++
+					}
+					if i == len(runes) {
+						break
+					}
+				}
+			}
+			ret = append(ret, string(runes[:i])+"\n")
+			runes = trimBreakSpaces(runes[i:])
+			existing = 0
+		}
+		if len(runes) > 0 {
+			ret = append(ret, string(runes))
+			existing += runewidth.StringWidth(string(runes))
+		}
+		if strings.HasSuffix(segment, "\n") {
+			existing = 0
+		}
+	}
+	return ret
+}
+
+// breakAtWbr breaks the line being written at its last wbr element, so
+// that a word is not broken between its inline elements instead. It
+// reports whether the line was broken.
+func (ctx *textifyTraverseContext) breakAtWbr() bool {
+	if !ctx.options.WbrBreaks || ctx.wbrAt <= 0 || ctx.wbrAt >= ctx.buf.Len() {
+		return false
+	}
+	b := ctx.buf.Bytes()
+	if b[ctx.wbrAt-1] == '\n' || bytes.IndexByte(b[ctx.wbrAt:], '\n') >= 0 {
+		// the wbr is not inside the line
+		return false
+	}
+	tail := string(b[ctx.wbrAt:])
+	ctx.buf.Truncate(ctx.wbrAt)
+	ctx.buf.WriteString("\n" + tail)
+	ctx.lineLength = runewidth.StringWidth(tail)
+	ctx.wbrAt = 0
+	return true
+}
+
+func isBreakSpace(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+func trimBreakSpaces(runes []rune) []rune {
+	i := 0
+	for i < len(runes) && isBreakSpace(runes[i]) {
+		i++
+	}
+	return runes[i:]
+}
+
+func (ctx *textifyTraverseContext) normalizeHrefLink(link string) (string, error) {
+	return ctx.normalizeLink(link, ctx.options.BaseURL, false)
+}
+
+// normalizeLink cleans up link and resolves it against baseURL unless baseURL is empty.
+// With keepFragment, a fragment lost resolving link is kept as is.
+func (ctx *textifyTraverseContext) normalizeLink(link, baseURL string, keepFragment bool) (string, error) {
+	if link == "" {
+		return link, nil
+	}
+	if strings.HasPrefix(link, "#") {
+		return link[1:], nil
+	}
+	if !ctx.options.ShowLongDataURL && strings.HasPrefix(link, "data:") && len(link) > 100 {
+		splitted := strings.Split(link, ";")
+		if len(splitted) > 0 {
+			return splitted[0] + ";(omitted)", nil
+		}
+		return "data:(omitted)", nil
+	}
+
+	link = strings.TrimSpace(link)
+	link = strings.ReplaceAll(link, "\n", "")
+	if baseURL != "" {
+		fragment := ""
+		u, err := url.Parse(link)
+		if err != nil {
+			s := err.Error()
+			// try to ignore invalid part and use vaid part
+			// parse "ssssss#%_tbar": invalid URL escape "%_t"
+			message := "invalid URL escape "
+			if !strings.Contains(s, message) {
+				return "", err
+			}
+			splitted := strings.Split(s, message)
+			invalid := strings.Trim(splitted[len(splitted)-1], `"`)
+			validPart := strings.Split(link, invalid)[0]
+			u, err = url.Parse(validPart)
+			if err != nil {
+				return "", err
+			}
+			// the invalid part is lost resolving, so keep a fragment holding it as is
+			if i := strings.Index(validPart, "#"); keepFragment && i >= 0 {
+				u.Fragment = ""
+				fragment = link[i:]
+			}
+		}
+		base, err := url.Parse(baseURL)
+		if err != nil {
+			return "", err
+		}
+		link = base.ResolveReference(u).String() + fragment
+	}
+	return link, nil
+}
+
+// toOrgFileLink rewrites file:// URLs and relative paths to Org file: links.
+// Other links are returned as is, including paths relative to the site root,
+// which are no paths of the file system.
+func toOrgFileLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	if u.Scheme == "file" || (u.Scheme == "" && u.Host == "" && u.Path != "" && !strings.HasPrefix(u.Path, "/")) {
+		return "file:" + u.Path
+	}
+	return link
+}
+
+// nonEmptyColumns returns the indices of the columns having a non-blank cell in rows.
+func nonEmptyColumns(rows [][]string) []int {
+	kept := []int{}
+	for i := 0; ; i++ {
+		exists, empty := false, true
+		for _, row := range rows {
+			if i < len(row) {
+				exists = true
+				empty = empty && strings.TrimSpace(row[i]) == ""
+			}
+		}
+		if !exists {
+			return kept
+		}
+		if !empty {
+			kept = append(kept, i)
+		}
+	}
+}
+
+// selectColumns returns the cells of row at the indices, skipping those past its end.
+func selectColumns(row []string, indices []int) []string {
+	res := []string{}
+	for _, i := range indices {
+		if i < len(row) {
+			res = append(res, row[i])
+		}
+	}
+	return res
+}
+
+// isRowHeader reports whether th heads a row of data cells rather than a column.
+func isRowHeader(th *html.Node) bool {
+	for c := th.Parent.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom == atom.Td {
+			return true
+		}
+	}
+	return false
+}
+
+// isTableRule reports whether line is a border or a separator line drawn by
+// tablewriter rather than a row of cells. Rules start with a corner or a
+// dash and have no column separators, while rows start with a column
+// separator or the padding of their first cell, so cells such as "-" or
+// "+" do not make a row a rule.
+func isTableRule(line string, options *PrettyTablesOptions) bool {
+	line = strings.TrimRight(line, " ")
+	if !strings.HasPrefix(line, options.CenterSeparator) && !strings.HasPrefix(line, options.RowSeparator) {
+		return false
+	}
+	if !strings.Contains(line, options.CenterSeparator) {
+		return false
+	}
+	for _, sep := range []string{options.RowSeparator, options.CenterSeparator} {
+		line = strings.Replace(line, sep, "", -1)
+	}
+	// merged cells leave gaps in the rules beside them
+	return strings.TrimSpace(line) == ""
+}
+
+// isHeaderRow reports whether all the cells of tr, th or td, belong to the
+// header: tr is in a thead, or it is the first row of a table and has the
+// only th cells of the table.
+func isHeaderRow(tr *html.Node) bool {
+	if tr == nil || tr.DataAtom != atom.Tr {
+		return false
+	}
+	if tr.Parent != nil && tr.Parent.DataAtom == atom.Thead {
+		return true
+	}
+	// only the first row can be a header, which saves scanning the table
+	// for the cells of every other row
+	table := rowTable(tr)
+	return table != nil && firstRow(table) == tr && thHeaderRow(table) == tr
+}
+
+// isHeaderRow is isHeaderRow for the rows of the table of tableCtx, whose
+// first row of th cells is known.
+func (tableCtx *tableTraverseContext) isHeaderRow(tr *html.Node) bool {
+	if tr == nil || tr.DataAtom != atom.Tr {
+		return false
+	}
+	return tr.Parent != nil && tr.Parent.DataAtom == atom.Thead || tr == tableCtx.thRow
+}
+
+// thHeaderRow returns the first row of table if it has th cells and no
+// other row outside the tfoot does, or nil.
+func thHeaderRow(table *html.Node) *html.Node {
+	rows := tableRows(table)
+	if len(rows) == 0 || !hasChildElement(rows[0], atom.Th) {
+		return nil
+	}
+	for _, row := range rows[1:] {
+		if hasChildElement(row, atom.Th) {
+			return nil
+		}
+	}
+	return rows[0]
+}
+
+// rowTable returns the table holding tr, or nil.
+func rowTable(tr *html.Node) *html.Node {
+	table := tr.Parent
+	if table != nil && table.DataAtom != atom.Table {
+		table = table.Parent
+	}
+	if table == nil || table.DataAtom != atom.Table {
+		return nil
+	}
+	return table
+}
+
+// firstRow returns the first row outside the tfoot of table, or nil.
+func firstRow(table *html.Node) *html.Node {
+	for c := table.FirstChild; c != nil; c = c.NextSibling {
+		switch c.DataAtom {
+		case atom.Tr:
+			return c
+		case atom.Thead, atom.Tbody:
+			for r := c.FirstChild; r != nil; r = r.NextSibling {
+				if r.DataAtom == atom.Tr {
+					return r
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// tableRows returns the rows outside the tfoot of table.
+func tableRows(table *html.Node) []*html.Node {
+	rows := []*html.Node{}
+	for c := table.FirstChild; c != nil; c = c.NextSibling {
+		switch c.DataAtom {
+		case atom.Tr:
+			rows = append(rows, c)
+		case atom.Thead, atom.Tbody:
+			for r := c.FirstChild; r != nil; r = r.NextSibling {
+				if r.DataAtom == atom.Tr {
+					rows = append(rows, r)
+				}
+			}
+		}
+	}
+	return rows
+}
+
+// rowHeaderText emphasizes the content of a row header cell.
+func rowHeaderText(s string) string {
+	if s = strings.TrimSpace(s); s == "" {
+		return ""
+	}
+	return "*" + s + "*"
+}
+
+// renderSimpleTable renders the rows of tableCtx as an Org table without
+// alignment, separating the header and the footer with hlines.
+func renderSimpleTable(tableCtx *tableTraverseContext) string {
+	groups := [][][]string{}
+	for _, rows := range [][][]string{{tableCtx.header}, nonEmptyRows(tableCtx.body), {tableCtx.footer}} {
+		if rows = nonEmptyRows(rows); len(rows) > 0 {
+			groups = append(groups, rows)
+		}
+	}
+	columns := 0
+	for _, rows := range groups {
+		for _, row := range rows {
+			if len(row) > columns {
+				columns = len(row)
+			}
+		}
+	}
+
+	lines := []string{}
+	for i, rows := range groups {
+		if i > 0 {
+			lines = append(lines, "|---|")
+		}
+		for _, row := range rows {
+			cells := make([]string, columns)
+			for j, cell := range row {
+				cell = strings.TrimSpace(strings.ReplaceAll(cell, "\n", " "))
+				cells[j] = strings.ReplaceAll(cell, "|", "\\vert{}")
+			}
+			lines = append(lines, "| "+strings.Join(cells, " | ")+" |")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// nonEmptyRows drops rows without cells, e.g. those holding only header cells.
+func nonEmptyRows(rows [][]string) [][]string {
+	res := [][]string{}
+	for _, row := range rows {
+		if len(row) > 0 {
+			res = append(res, row)
+		}
+	}
+	return res
+}
+
+// renderCell renders the content of a table cell. A cell holding only
+// non-breaking spaces is an intentional spacer and is kept as a single space.
+func (ctx *textifyTraverseContext) renderCell(node *html.Node) (string, error) {
+	res, err := ctx.renderEachChild(node)
+	if err != nil || res != "" {
+		return res, err
+	}
+	text := textContent(node)
+	if strings.ContainsRune(text, '\u00a0') && strings.TrimSpace(text) == "" {
+		return " ", nil
+	}
+	return res, nil
+}
+
+// renderEachChild visits each direct child of a node and collects the sequence of
+// textuual representaitons separated by a single newline.
+func (ctx *textifyTraverseContext) renderEachChild(node *html.Node) (string, error) {
+	buf := &bytes.Buffer{}
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		cellCtx := textifyTraverseContext{
+			options:         ctx.options,
+			fragmentIDs:     ctx.fragmentIDs,
+			isInForm:        ctx.isInForm,
+			formID:          ctx.formID,
+			forms:           ctx.forms,
+			stats:           ctx.stats,
+			headings:        ctx.headings,
+			progress:        ctx.progress,
+			footnotes:       ctx.footnotes,
+			dropSelectors:   ctx.dropSelectors,
+			lastOrderedList: ctx.lastOrderedList,
+			isInTableCell:   true,
+		}
+		if err := cellCtx.traverse(c); err != nil {
+			return "", err
+		}
+		raw := cellCtx.buf.String()
+		s := postProcess(raw, "")
+		// keep words apart from the inline elements next to them
+		if c.Type == html.TextNode && strings.TrimSpace(raw) != raw {
+			if strings.HasPrefix(raw, " ") && buf.Len() > 0 {
+				s = " " + s
+			}
+			if strings.HasSuffix(raw, " ") && s != " " {
+				s += " "
+			}
+		}
+		if _, err := buf.WriteString(s); err != nil {
+			return "", err
+		}
+
+		if _, isBlockLevel := blockLevelAtoms[c.DataAtom]; c.NextSibling != nil && isBlockLevel {
+			if err := buf.WriteByte('\n'); err != nil {
+				return "", err
+			}
+		}
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func getAttrVal(node *html.Node, attrName string) string {
+	for _, attr := range node.Attr {
+		if attr.Key == attrName {
+			return attr.Val
+		}
+	}
+
+	return ""
+}
+
+// getStyleVal returns the value of the CSS property prop in the style attribute.
+func getStyleVal(node *html.Node, prop string) string {
+	for _, decl := range strings.Split(getAttrVal(node, "style"), ";") {
+		kv := strings.SplitN(decl, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(kv[0]), prop) {
+			return strings.ToLower(strings.TrimSpace(kv[1]))
+		}
+	}
+	return ""
+}
+
+// colAlignments returns the alignment of each column of table as defined by
+// its col and colgroup elements. A span repeats the definition over that
+// many columns.
+func colAlignments(table *html.Node) []int {
+	alignments := []int{}
+	hasAlignment := false
+	add := func(node *html.Node, alignment int) {
+		span, err := strconv.Atoi(strings.TrimSpace(getAttrVal(node, "span")))
+		if err != nil || span < 1 {
+			span = 1
+		} else if span > 1000 {
+			// same limit as browsers
+			span = 1000
+		}
+		for i := 0; i < span; i++ {
+			alignments = append(alignments, alignment)
+		}
+		hasAlignment = hasAlignment || alignment != tablewriter.ALIGN_DEFAULT
+	}
+	for group := table.FirstChild; group != nil; group = group.NextSibling {
+		if group.DataAtom != atom.Colgroup {
+			continue
+		}
+		groupAlignment := colAlignment(group)
+		hasCol := false
+		for col := group.FirstChild; col != nil; col = col.NextSibling {
+			if col.DataAtom != atom.Col {
+				continue
+			}
+			hasCol = true
+			alignment := colAlignment(col)
+			if alignment == tablewriter.ALIGN_DEFAULT {
+				alignment = groupAlignment
+			}
+			add(col, alignment)
 		}
-		return ctx.emit(data)
-
-	case html.ElementNode:
-		if err := ctx.handleElement(node); err != nil {
-			return err
+		if !hasCol {
+			add(group, groupAlignment)
 		}
+	}
+	if !hasAlignment {
+		return []int{}
+	}
+	return alignments
+}
 
-		return ctx.handleInternalLinks(node)
+func colAlignment(node *html.Node) int {
+	align := getStyleVal(node, "text-align")
+	if align == "" {
+		align = strings.ToLower(strings.TrimSpace(getAttrVal(node, "align")))
+	}
+	switch align {
+	case "left":
+		return tablewriter.ALIGN_LEFT
+	case "right":
+		return tablewriter.ALIGN_RIGHT
+	case "center":
+		return tablewriter.ALIGN_CENTER
 	}
+	return tablewriter.ALIGN_DEFAULT
+}
 
+func isTopLevelArticle(node *html.Node) bool {
+	for p := node.Parent; p != nil; p = p.Parent {
+		if p.DataAtom == atom.Article {
+			return false
+		}
+	}
+	return true
 }
 
-func (ctx *textifyTraverseContext) traverseChildren(node *html.Node) error {
+func hasChildElement(node *html.Node, a atom.Atom) bool {
 	for c := node.FirstChild; c != nil; c = c.NextSibling {
-		if err := ctx.traverse(c); err != nil {
-			return err
+		if c.DataAtom == a {
+			return true
 		}
 	}
+	return false
+}
 
-	return nil
+// indentContinuationLines indents the non-empty lines of s after the first
+// by n spaces.
+func indentContinuationLines(s string, n int) string {
+	lines := strings.Split(s, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = strings.Repeat(" ", n) + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
 }
 
-func (ctx *textifyTraverseContext) emit(data string) error {
-	if data == "" {
-		return nil
+// separatesSimilarBlocks reports whether node, only surrounded by
+// whitespace, sits between two elements with the same tag and class.
+func separatesSimilarBlocks(node *html.Node) bool {
+	prev, next := adjacentElement(node.PrevSibling, false), adjacentElement(node.NextSibling, true)
+	if prev == nil || next == nil || prev.DataAtom == atom.Hr || next.DataAtom == atom.Hr {
+		return false
 	}
-	var (
-		lines = ctx.breakLongLines(data)
-		// lines = strings.Split(data, "\n") TODO
-		err error
-	)
-	for _, line := range lines {
-		runes := []rune(line)
+	return prev.Data == next.Data && getAttrVal(prev, "class") == getAttrVal(next, "class")
+}
 
-		if !ctx.isPreFormatted && ctx.endsWithNewLine {
-			line = strings.TrimPrefix(line, " ")
-			if ctx.prefix != "" {
-				ctx.endsWithNewLine = false
-				if _, err = ctx.buf.WriteString(ctx.prefix); err != nil {
-					return err
-				}
-			}
+// adjacentElement returns the element at or after s, or before it when
+// forward is false, skipping whitespace and comments. It returns nil if
+// other text comes first.
+func adjacentElement(s *html.Node, forward bool) *html.Node {
+	for s != nil {
+		switch {
+		case s.Type == html.ElementNode:
+			return s
+		case s.Type == html.TextNode && !isBlank(s.Data):
+			return nil
 		}
-
-		if line != "" {
-			ctx.endsWithNewLine = runes[len(runes)-1] == '\n'
+		if forward {
+			s = s.NextSibling
+		} else {
+			s = s.PrevSibling
 		}
+	}
+	return nil
+}
 
-		for _, c := range line {
-			if _, err = ctx.buf.WriteString(string(c)); err != nil {
-				return err
-			}
-			ctx.lineLength++
-			if c == '\n' {
-				ctx.lineLength = 0
-			}
+func prevElementSibling(node *html.Node) *html.Node {
+	for s := node.PrevSibling; s != nil; s = s.PrevSibling {
+		if s.Type == html.ElementNode {
+			return s
 		}
 	}
 	return nil
 }
 
-const maxLineLen = 74
-
-func (ctx *textifyTraverseContext) breakLongLines(data string) []string {
-	// Only break lines when in blockquotes.
-	if ctx.blockquoteLevel == 0 || !ctx.options.BreakLongLines {
-		return []string{data}
-	}
-	var (
-		ret      = []string{}
-		runes    = []rune(data)
-		l        = len(runes)
-		existing = ctx.lineLength
-	)
-	if existing >= maxLineLen {
-		ret = append(ret, "\n")
-		existing = 0
+// isStylingSpan reports whether node is a span with no attributes other than class and style.
+func isStylingSpan(node *html.Node) bool {
+	if node.DataAtom != atom.Span {
+		return false
 	}
-	for l+existing > maxLineLen {
-		i := maxLineLen - existing
-		for i >= 0 && !unicode.IsSpace(runes[i]) {
-			i--
-		}
-		if i == -1 {
-			// No spaces, so go the other way.
-			i = maxLineLen - existing
-			for i < l && !unicode.IsSpace(runes[i]) {
-				i++
-			}
-		}
-		ret = append(ret, string(runes[:i])+"\n")
-		for i < l && unicode.IsSpace(runes[i]) {
-			i++
+	for _, attr := range node.Attr {
+		if attr.Key != "class" && attr.Key != "style" {
+			return false
 		}
-		runes = runes[i:]
-		l = len(runes)
-		existing = 0
 	}
-	if len(runes) > 0 {
-		ret = append(ret, string(runes))
-	}
-	return ret
+	return true
 }
 
-func (ctx *textifyTraverseContext) normalizeHrefLink(link string) (string, error) {
-	if link == "" {
-		return link, nil
-	}
-	if strings.HasPrefix(link, "#") {
-		return link[1:], nil
+// hasAnyClass reports whether the class attribute of node contains one of classes.
+func hasAnyClass(node *html.Node, classes []string) bool {
+	if len(classes) == 0 {
+		return false
 	}
-	if !ctx.options.ShowLongDataURL && strings.HasPrefix(link, "data:") && len(link) > 100 {
-		splitted := strings.Split(link, ";")
-		if len(splitted) > 0 {
-			return splitted[0] + ";(omitted)", nil
+	for _, c := range strings.Fields(getAttrVal(node, "class")) {
+		for _, class := range classes {
+			if c == class {
+				return true
+			}
 		}
-		return "data:(omitted)", nil
 	}
+	return false
+}
 
-	link = strings.TrimSpace(link)
-	link = strings.ReplaceAll(link, "\n", "")
-	if ctx.options.BaseURL != "" {
-		u, err := url.Parse(link)
-		if err != nil {
-			s := err.Error()
-			// try to ignore invalid part and use vaid part
-			// parse "ssssss#%_tbar": invalid URL escape "%_t"
-			message := "invalid URL escape "
-			if !strings.Contains(s, message) {
-				return "", err
+// hasAnyRel reports whether the rel attribute of node has one of rels.
+func hasAnyRel(node *html.Node, rels []string) bool {
+	for _, r := range strings.Fields(getAttrVal(node, "rel")) {
+		for _, rel := range rels {
+			if strings.EqualFold(r, rel) {
+				return true
 			}
-			splitted := strings.Split(s, message)
-			invalid := strings.Trim(splitted[len(splitted)-1], `"`)
-			validPart := strings.Split(link, invalid)[0]
-			u, err = url.Parse(validPart)
-			if err != nil {
-				return "", err
-			}
-		}
-		base, err := url.Parse(ctx.options.BaseURL)
-		if err != nil {
-			return "", err
 		}
-		link = base.ResolveReference(u).String()
 	}
-	return link, nil
+	return false
 }
 
-// renderEachChild visits each direct child of a node and collects the sequence of
-// textuual representaitons separated by a single newline.
-func (ctx *textifyTraverseContext) renderEachChild(node *html.Node) (string, error) {
-	buf := &bytes.Buffer{}
-	for c := node.FirstChild; c != nil; c = c.NextSibling {
-		s, err := FromHTMLNode(c, ctx.options)
-		if err != nil {
-			return "", err
-		}
-		if _, err = buf.WriteString(s); err != nil {
-			return "", err
-		}
-
-		if _, isBlockLevel := blockLevelAtoms[c.DataAtom]; c.NextSibling != nil && isBlockLevel {
-			if err = buf.WriteByte('\n'); err != nil {
-				return "", err
-			}
-		}
+func hasPreWhiteSpaceStyle(node *html.Node) bool {
+	switch getStyleVal(node, "white-space") {
+	case "pre", "pre-wrap", "break-spaces":
+		return true
 	}
-	return buf.String(), nil
+	return false
 }
 
-func getAttrVal(node *html.Node, attrName string) string {
+func hasAttr(node *html.Node, attrName string) bool {
 	for _, attr := range node.Attr {
 		if attr.Key == attrName {
-			return attr.Val
+			return true
 		}
 	}
-
-	return ""
+	return false
 }
 
 var blockLevelAtoms = map[atom.Atom]struct{}{
@@ -947,6 +3182,139 @@ func containsBlockLevelAtom(node *html.Node) bool {
 	return false
 }
 
+// containsLineBreak reports whether node has a <br> or block-level descendant,
+// i.e. whether its rendered content is genuinely multi-line.
+func containsLineBreak(node *html.Node) bool {
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if _, ok := blockLevelAtoms[c.DataAtom]; ok || c.DataAtom == atom.Br {
+			return true
+		}
+		if containsLineBreak(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// emitEmphasis emits str wrapped in the emphasis marker so that Org can
+// fontify it: surrounding whitespace is moved outside the markers and a
+// zero-width space separates a marker from an adjacent word character.
+func (ctx *textifyTraverseContext) emitEmphasis(node *html.Node, str, marker string) error {
+	inner := strings.TrimSpace(str)
+	if inner == "" {
+		return ctx.emit(str)
+	}
+	i := strings.Index(str, inner)
+	lead, trail := str[:i], str[i+len(inner):]
+	if err := ctx.emit(lead); err != nil {
+		return err
+	}
+	if r, _ := utf8.DecodeLastRune(ctx.buf.Bytes()); r != utf8.RuneError && !isEmphasisPre(r) {
+		inner = zeroWidthSpace + marker + inner
+	} else {
+		inner = marker + inner
+	}
+	inner += marker
+	if trail == "" {
+		if r := nextRune(node); r != 0 && !isEmphasisPost(r) {
+			inner += zeroWidthSpace
+		}
+	}
+	return ctx.emit(inner + trail)
+}
+
+const zeroWidthSpace = "\u200b"
+
+// isEmphasisPre reports whether r may precede an emphasis marker.
+// Invisible format characters such as zero-width spaces and bidi isolates
+// are accepted, as they already separate the marker.
+func isEmphasisPre(r rune) bool {
+	return unicode.IsSpace(r) || unicode.Is(unicode.Cf, r) || strings.ContainsRune("-('\"{", r)
+}
+
+// isEmphasisPost reports whether r may follow an emphasis marker.
+func isEmphasisPost(r rune) bool {
+	return unicode.IsSpace(r) || unicode.Is(unicode.Cf, r) || strings.ContainsRune("-.,;:!?'\")}[", r)
+}
+
+// nextRune returns the first rune rendered after node among its siblings,
+// or 0 if a line break, a block or the end of the parent comes first.
+func nextRune(node *html.Node) rune {
+	for c := node.NextSibling; c != nil; c = c.NextSibling {
+		if _, ok := blockLevelAtoms[c.DataAtom]; ok || c.DataAtom == atom.Br {
+			return 0
+		}
+		if s := textContent(c); s != "" {
+			r, _ := utf8.DecodeRuneInString(s)
+			return r
+		}
+	}
+	return 0
+}
+
+// textContent returns the concatenated text of all descendants of node.
+func textContent(node *html.Node) string {
+	if node.Type == html.TextNode {
+		return node.Data
+	}
+	buf := bytes.Buffer{}
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		buf.WriteString(textContent(c))
+	}
+	return buf.String()
+}
+
+// isBreakAtBlockEdge reports whether br only has other line breaks and
+// whitespace between it and the start or end of its block-level parent.
+func isBreakAtBlockEdge(br *html.Node) bool {
+	if br.Parent == nil {
+		return false
+	}
+	if _, ok := blockLevelAtoms[br.Parent.DataAtom]; !ok {
+		return false
+	}
+	isEdge := func(next func(*html.Node) *html.Node) bool {
+		for c := next(br); c != nil; c = next(c) {
+			if c.DataAtom == atom.Br || c.Type == html.CommentNode {
+				continue
+			}
+			if c.Type == html.TextNode && strings.TrimSpace(c.Data) == "" {
+				continue
+			}
+			return false
+		}
+		return true
+	}
+	return isEdge(func(n *html.Node) *html.Node { return n.PrevSibling }) ||
+		isEdge(func(n *html.Node) *html.Node { return n.NextSibling })
+}
+
+// isBlank reports whether s has only whitespace and invisible format
+// characters such as zero-width spaces.
+func isBlank(s string) bool {
+	return strings.TrimFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.Is(unicode.Cf, r)
+	}) == ""
+}
+
+var preSpacesRe = regexp.MustCompile(`  +`)
+
+// collapsePreSpaces collapses the runs of spaces in the lines of s to one
+// space, except the indentation of the lines which start a line of output.
+// lineStart tells whether the first line of s does.
+func collapsePreSpaces(s string, lineStart bool) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		indent := ""
+		if i > 0 || lineStart {
+			trimmed := strings.TrimLeft(line, " ")
+			indent, line = line[:len(line)-len(trimmed)], trimmed
+		}
+		lines[i] = indent + preSpacesRe.ReplaceAllString(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
 func cleanSpacing(s string) string {
 	s = spacingRe.ReplaceAllString(s, " ")
 	lastIsSpace := false
@@ -966,6 +3334,194 @@ func cleanSpacing(s string) string {
 	return buf.String()
 }
 
+// cleanSpacingKeepingBreaks is cleanSpacing for the text node except that
+// line breaks between words become Org forced line breaks.
+func cleanSpacingKeepingBreaks(node *html.Node) string {
+	s := node.Data
+	core := strings.TrimSpace(s)
+	if core == "" {
+		return cleanSpacing(s)
+	}
+	i := strings.Index(s, core)
+	lines := softBreakRe.Split(core, -1)
+	for j, line := range lines {
+		lines[j] = cleanSpacing(line)
+	}
+	lead, trail := cleanSpacing(s[:i]), cleanSpacing(s[i+len(core):])
+	// a break next to an inline sibling is still between words
+	if isSoftBreakSibling(node.PrevSibling) && strings.Contains(s[:i], "\n") {
+		lead = orgLineBreak
+	}
+	if isSoftBreakSibling(node.NextSibling) && strings.Contains(s[i+len(core):], "\n") {
+		trail = orgLineBreak
+	}
+	return lead + strings.Join(lines, orgLineBreak) + trail
+}
+
+const orgLineBreak = "\\\\\n"
+
+func isSoftBreakSibling(node *html.Node) bool {
+	if node == nil || node.DataAtom == atom.Br {
+		return false
+	}
+	_, isBlockLevel := blockLevelAtoms[node.DataAtom]
+	return !isBlockLevel
+}
+
+// linkDataComment returns the attributes of link listed in names as an
+// inline comment, which Org drops on export, e.g.
+// @@comment:data-track="nav"@@. A name ending in "*" matches by prefix.
+func linkDataComment(link *html.Node, names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	attrs := []string{}
+	for _, attr := range link.Attr {
+		for _, name := range names {
+			if attr.Key == name || strings.HasSuffix(name, "*") && strings.HasPrefix(attr.Key, strings.TrimSuffix(name, "*")) {
+				attrs = append(attrs, fmt.Sprintf("%s=%q", attr.Key, attr.Val))
+				break
+			}
+		}
+	}
+	if len(attrs) == 0 {
+		return ""
+	}
+	return " @@comment:" + strings.Join(attrs, " ") + "@@"
+}
+
+// srcLanguage returns the language of a pre or code element, prefixed with
+// a space for the #+begin_src line, or "" if it is unknown. A language class
+// such as "language-go" takes priority over the data-lang and lang
+// attributes, and the element over the code elements it wraps.
+func srcLanguage(node *html.Node) string {
+	nodes := []*html.Node{node}
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom == atom.Code {
+			nodes = append(nodes, c)
+		}
+	}
+	for _, n := range nodes {
+		for _, c := range strings.Fields(getAttrVal(n, "class")) {
+			for _, prefix := range []string{"language-", "lang-"} {
+				if strings.HasPrefix(c, prefix) && len(c) > len(prefix) {
+					return " " + strings.ToLower(c[len(prefix):])
+				}
+			}
+		}
+	}
+	for _, attr := range []string{"data-lang", "lang"} {
+		for _, n := range nodes {
+			if lang := strings.Fields(getAttrVal(n, attr)); len(lang) > 0 {
+				return " " + strings.ToLower(lang[0])
+			}
+		}
+	}
+	return ""
+}
+
+// isCodePre reports whether the pre element holds code, i.e. it wraps a
+// code element or has a language class such as "language-go".
+func isCodePre(node *html.Node) bool {
+	for _, c := range strings.Fields(getAttrVal(node, "class")) {
+		if strings.HasPrefix(c, "language-") || strings.HasPrefix(c, "lang-") {
+			return true
+		}
+	}
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom == atom.Code {
+			return true
+		}
+	}
+	return false
+}
+
+// isInParagraph reports whether node is rendered as part of a p element.
+func isInParagraph(node *html.Node) bool {
+	for p := node.Parent; p != nil; p = p.Parent {
+		if p.DataAtom == atom.P {
+			return true
+		}
+		if _, ok := blockLevelAtoms[p.DataAtom]; ok {
+			return false
+		}
+	}
+	return false
+}
+
+// isInTableCell reports whether node is inside a td or th element.
+func isInTableCell(node *html.Node) bool {
+	for p := node.Parent; p != nil; p = p.Parent {
+		if p.DataAtom == atom.Td || p.DataAtom == atom.Th {
+			return true
+		}
+	}
+	return false
+}
+
+// isInNestedBlock reports whether node is inside a list item, a table cell
+// or a quote, where a heading is rendered as a line of text rather than as a
+// headline.
+func isInNestedBlock(node *html.Node) bool {
+	for p := node.Parent; p != nil; p = p.Parent {
+		switch p.DataAtom {
+		case atom.Li, atom.Dd, atom.Dt, atom.Td, atom.Th, atom.Blockquote:
+			return true
+		}
+	}
+	return false
+}
+
+// isInLink reports whether node is inside an a element.
+func isInLink(node *html.Node) bool {
+	for p := node.Parent; p != nil; p = p.Parent {
+		if p.DataAtom == atom.A {
+			return true
+		}
+	}
+	return false
+}
+
+// isInLinkOrCode reports whether node is inside a link or an element
+// holding code, whose text must not be linkified.
+func isInLinkOrCode(node *html.Node) bool {
+	for p := node.Parent; p != nil; p = p.Parent {
+		switch p.DataAtom {
+		case atom.A, atom.Code, atom.Kbd, atom.Samp, atom.Tt, atom.Var, atom.Pre:
+			return true
+		}
+	}
+	return false
+}
+
+var bareLinkRe = regexp.MustCompile(`https?://[^\s<>\[\]"]+|[\w.%+-]+@[\w-]+(?:\.[\w-]+)*\.[A-Za-z]{2,}`)
+
+// linkify wraps the bare URLs and email addresses of s in Org links and
+// returns how many there are. Punctuation ending a sentence is not part of a
+// URL, nor is a closing parenthesis without its opening one.
+func linkify(s string) (string, int) {
+	n := 0
+	s = bareLinkRe.ReplaceAllStringFunc(s, func(match string) string {
+		n++
+		link := match
+		if strings.HasPrefix(link, "http") {
+			for {
+				trimmed := strings.TrimRight(link, ".,;:!?'")
+				if strings.HasSuffix(trimmed, ")") && strings.Count(trimmed, ")") > strings.Count(trimmed, "(") {
+					trimmed = trimmed[:len(trimmed)-1]
+				}
+				if trimmed == link {
+					break
+				}
+				link = trimmed
+			}
+			return "[[" + link + "]]" + match[len(link):]
+		}
+		return "[[mailto:" + link + "][" + link + "]]"
+	})
+	return s, n
+}
+
 func normalizeNonBreakingSpace(s string) string {
 	buf := bytes.Buffer{}
 	for _, c := range s {
@@ -0,0 +1,106 @@
+package html2org
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Issue is a structural problem found in Org text by Lint.
+type Issue struct {
+	Line    int // 1-based line number
+	Message string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("line %d: %s", i.Line, i.Message)
+}
+
+var (
+	lintBeginRe  = regexp.MustCompile(`(?i)^#\+begin_(\S+)`)
+	lintEndRe    = regexp.MustCompile(`(?i)^#\+end_(\S+)`)
+	lintDrawerRe = regexp.MustCompile(`^:([\w-]+):$`)
+	lintTableRe  = regexp.MustCompile(`^\|-[-+|]*$`)
+)
+
+type lintBlock struct {
+	name string
+	line int
+}
+
+// Lint scans Org text for structural problems of the constructs the
+// converter emits: unbalanced #+begin_/#+end_ blocks, unclosed drawers
+// and table rows whose number of cells differs from the first row.
+// Lines inside blocks are not checked, as their content is verbatim.
+func Lint(orgText string) []Issue {
+	issues := []Issue{}
+	var (
+		blocks     []lintBlock
+		drawer     *lintBlock
+		tableCells int // cells in the first row of the current table; 0 outside tables
+	)
+	for i, line := range strings.Split(orgText, "\n") {
+		n := i + 1
+		trimmed := strings.TrimSpace(line)
+
+		if m := lintEndRe.FindStringSubmatch(trimmed); m != nil {
+			name := strings.ToLower(m[1])
+			if len(blocks) == 0 {
+				issues = append(issues, Issue{n, fmt.Sprintf("#+end_%s without #+begin_%s", m[1], m[1])})
+				continue
+			}
+			open := blocks[len(blocks)-1]
+			if open.name != name {
+				issues = append(issues, Issue{n, fmt.Sprintf("#+end_%s closes #+begin_%s of line %d", m[1], open.name, open.line)})
+			}
+			blocks = blocks[:len(blocks)-1]
+			continue
+		}
+		if m := lintBeginRe.FindStringSubmatch(trimmed); m != nil {
+			blocks = append(blocks, lintBlock{strings.ToLower(m[1]), n})
+			tableCells = 0
+			continue
+		}
+		if len(blocks) > 0 {
+			continue
+		}
+
+		if m := lintDrawerRe.FindStringSubmatch(trimmed); m != nil {
+			if strings.EqualFold(m[1], "END") {
+				if drawer == nil {
+					issues = append(issues, Issue{n, ":END: without an open drawer"})
+				}
+				drawer = nil
+			} else if drawer != nil {
+				issues = append(issues, Issue{n, fmt.Sprintf(":%s: opened inside drawer :%s: of line %d", m[1], drawer.name, drawer.line)})
+			} else {
+				drawer = &lintBlock{m[1], n}
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(trimmed, "|") {
+			tableCells = 0
+			continue
+		}
+		if lintTableRe.MatchString(trimmed) {
+			continue
+		}
+		cells := strings.Count(strings.TrimSuffix(trimmed, "|"), "|")
+		if tableCells == 0 {
+			tableCells = cells
+		} else if cells != tableCells {
+			issues = append(issues, Issue{n, fmt.Sprintf("table row has %d cells, the first row has %d", cells, tableCells)})
+		}
+	}
+
+	for _, open := range blocks {
+		issues = append(issues, Issue{open.line, fmt.Sprintf("#+begin_%s is not closed", open.name)})
+	}
+	if drawer != nil {
+		issues = append(issues, Issue{drawer.line, fmt.Sprintf(":%s: drawer is not closed", drawer.name)})
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Line < issues[j].Line })
+	return issues
+}
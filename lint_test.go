@@ -0,0 +1,68 @@
+package html2org
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	testCases := []struct {
+		input  string
+		issues string
+	}{
+		{
+			"#+begin_quote\nquoted\n#+end_quote\n\n| a | b |\n|---+---|\n| c | d |",
+			"[]",
+		},
+		{
+			"#+begin_src go\nfmt.Println()\n",
+			"[line 1: #+begin_src is not closed]",
+		},
+		{
+			"text\n#+end_example",
+			"[line 2: #+end_example without #+begin_example]",
+		},
+		{
+			"#+begin_quote\n#+begin_example\n#+end_quote\n#+end_example",
+			"[line 3: #+end_quote closes #+begin_example of line 2 line 4: #+end_example closes #+begin_quote of line 1]",
+		},
+		{
+			"| a | b |\n|---+---|\n| c | d | e |\n\n| x |",
+			"[line 3: table row has 3 cells, the first row has 2]",
+		},
+		{
+			"#+begin_example\n| a | b |\n| c |\n:END:\n#+end_example",
+			"[]",
+		},
+		{
+			"- item\n:DETAILS:\nhidden\n\nmore text",
+			"[line 2: :DETAILS: drawer is not closed]",
+		},
+		{
+			":END:",
+			"[line 1: :END: without an open drawer]",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if got := fmt.Sprint(Lint(testCase.input)); got != testCase.issues {
+			t.Errorf("\ninput: %q\ngot : %s\nwant: %s", testCase.input, got, testCase.issues)
+		}
+	}
+}
+
+func TestLintConverted(t *testing.T) {
+	input := `<blockquote><p>quote</p></blockquote>
+<table><tr><th>a</th><th>b</th></tr><tr><td>c</td><td>d</td></tr></table>
+<pre>code</pre>
+<details><summary>More</summary>hidden</details>`
+	for _, options := range []Options{{}, {PrettyTables: true, DetailsAsDrawer: true}} {
+		text, err := FromString(input, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if issues := Lint(text); len(issues) > 0 {
+			t.Errorf("\n%s\nissues: %v", text, issues)
+		}
+	}
+}
@@ -5,7 +5,7 @@ git tag
 
 read -p "next version?: " tag
 
-version=./cmd/html2org/VERSION
+version=./VERSION
 echo "${tag:1}" > $version
 git add $version
 git commit -m "release $tag"
@@ -0,0 +1,106 @@
+package html2org
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// selector is a parsed CSS selector made of compound selectors joined by
+// descendant combinators, e.g. "nav a.external".
+type selector []compoundSelector
+
+// compoundSelector matches an element by tag, classes and id, e.g. "a.ext#top".
+type compoundSelector struct {
+	tag     string // "" or "*" matches any tag
+	id      string
+	classes []string
+}
+
+// parseSelector parses the subset of CSS selectors supported by
+// Options.DropSelectors: tags, .class, #id and the descendant combinator.
+func parseSelector(s string) (selector, error) {
+	sel := selector{}
+	for _, part := range strings.Fields(s) {
+		compound, err := parseCompoundSelector(part)
+		if err != nil {
+			return nil, fmt.Errorf("html2org: selector %q: %v", s, err)
+		}
+		sel = append(sel, compound)
+	}
+	if len(sel) == 0 {
+		return nil, fmt.Errorf("html2org: empty selector")
+	}
+	return sel, nil
+}
+
+func parseCompoundSelector(s string) (compoundSelector, error) {
+	var compound compoundSelector
+	i := strings.IndexAny(s, ".#")
+	if i < 0 {
+		i = len(s)
+	}
+	compound.tag = strings.ToLower(s[:i])
+	for s = s[i:]; s != ""; {
+		kind := s[0]
+		s = s[1:]
+		end := strings.IndexAny(s, ".#")
+		if end < 0 {
+			end = len(s)
+		}
+		name := s[:end]
+		s = s[end:]
+		if name == "" {
+			return compound, fmt.Errorf("missing name after %q", kind)
+		}
+		if kind == '.' {
+			compound.classes = append(compound.classes, name)
+		} else {
+			compound.id = name
+		}
+	}
+	for _, name := range append([]string{compound.tag, compound.id}, compound.classes...) {
+		if strings.ContainsAny(name, ">+~[]:(),*") && name != "*" {
+			return compound, fmt.Errorf("unsupported syntax %q", name)
+		}
+	}
+	return compound, nil
+}
+
+func (compound compoundSelector) matches(node *html.Node) bool {
+	if node.Type != html.ElementNode {
+		return false
+	}
+	if compound.tag != "" && compound.tag != "*" && compound.tag != node.Data {
+		return false
+	}
+	if compound.id != "" && getAttrVal(node, "id") != compound.id {
+		return false
+	}
+	return hasAllClasses(node, compound.classes)
+}
+
+// matches reports whether node matches the last compound selector and its
+// ancestors match the preceding ones in order.
+func (sel selector) matches(node *html.Node) bool {
+	if !sel[len(sel)-1].matches(node) {
+		return false
+	}
+	i := len(sel) - 2
+	for p := node.Parent; p != nil && i >= 0; p = p.Parent {
+		if sel[i].matches(p) {
+			i--
+		}
+	}
+	return i < 0
+}
+
+func hasAllClasses(node *html.Node, classes []string) bool {
+	for _, class := range classes {
+		if !hasAnyClass(node, []string{class}) {
+			return false
+		}
+	}
+	return true
+}
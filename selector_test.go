@@ -0,0 +1,58 @@
+package html2org
+
+import (
+	"testing"
+)
+
+func TestDropSelectors(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<p>Text</p><div class="advert big">Buy now</div><p>More <span class="advert">ad</span></p>`,
+			"Text\n\nMore",
+		},
+		{
+			`<main><p>Body</p></main><div id="footer"><p>Footer</p></div>`,
+			"Body",
+		},
+		{
+			`<nav><a href="/x">x</a> menu</nav><p><a href="/y">y</a></p>`,
+			"menu\n\n[[/y][y]]",
+		},
+		{
+			`<div class="advert-free">kept</div><p id="footer-note" class="advert x">dropped</p>`,
+			"kept",
+		},
+	}
+
+	options := Options{DropSelectors: []string{".advert", "#footer", "nav a"}}
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	valid := []string{"div", ".a", "#b", "div.a.b#c", "nav a", "* .x", "UL  li"}
+	for _, s := range valid {
+		if _, err := parseSelector(s); err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+
+	invalid := []string{"", "ul > li", "a[href]", "a:hover", "div.", "a#", "h1, h2"}
+	for _, s := range invalid {
+		if _, err := parseSelector(s); err == nil {
+			t.Errorf("%q: want error", s)
+		}
+	}
+
+	if _, err := FromString("<p>x</p>", Options{DropSelectors: []string{"a + b"}}); err == nil {
+		t.Error("want error for unsupported selector")
+	}
+}